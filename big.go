@@ -0,0 +1,233 @@
+package encoding
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+	bigRatType   = reflect.TypeOf(big.Rat{})
+)
+
+func encodeBigInt(enc *encode, v reflect.Value) error {
+	return enc.bigInt(addressable(v).Addr().Interface().(*big.Int))
+}
+
+func decodeBigInt(d *decode, v reflect.Value) error {
+	return d.bigInt(v.Addr().Interface().(*big.Int))
+}
+
+// encodeBigFloat keeps the precision, the rounding mode and the accuracy of the value
+func encodeBigFloat(enc *encode, v reflect.Value) error {
+	data, err := addressable(v).Addr().Interface().(*big.Float).GobEncode()
+	if err != nil {
+		return err
+	}
+	return enc.bytes(data)
+}
+
+func decodeBigFloat(d *decode, v reflect.Value) error {
+	data, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	return v.Addr().Interface().(*big.Float).GobDecode(data)
+}
+
+// encodeBigRat writes the numerator and the denominator
+func encodeBigRat(enc *encode, v reflect.Value) error {
+	rat := addressable(v).Addr().Interface().(*big.Rat)
+	if err := enc.bigInt(rat.Num()); err != nil {
+		return err
+	}
+	return enc.bigInt(rat.Denom())
+}
+
+func decodeBigRat(d *decode, v reflect.Value) error {
+	var num, denom big.Int
+	if err := d.bigInt(&num); err != nil {
+		return err
+	}
+	if err := d.bigInt(&denom); err != nil {
+		return err
+	}
+	if denom.Sign() == 0 {
+		return fmt.Errorf("encoding: zero denominator in big.Rat")
+	}
+	v.Addr().Interface().(*big.Rat).SetFrac(&num, &denom)
+	return nil
+}
+
+// decimal stores a number as an integer scaled by 10^scale, with at most precision digits,
+// in the style of ClickHouse Decimal(P,S). Integer fields hold the scaled value as is
+type decimal struct {
+	precision int
+	scale     int
+	// limit is 10^precision, unit is 10^scale
+	limit *big.Int
+	unit  *big.Int
+}
+
+// maxDecimalPrecision is the precision of ClickHouse Decimal256
+const maxDecimalPrecision = 76
+
+// decimalCodec returns the codecs of the fields tagged with the decimal=P.S option
+func decimalCodec(t reflect.Type, spec string) (encodeFunc, decodeFunc) {
+	if t.Kind() == reflect.Ptr {
+		encode, decode := decimalCodec(t.Elem(), spec)
+		return ptrEncodeFunc(encode), ptrDecodeFunc(decode)
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	default:
+		if t != bigIntType && t != bigFloatType && t != bigRatType {
+			return errorCodec(fmt.Errorf("encoding: decimal option is not supported for %s", t))
+		}
+	}
+	d, err := parseDecimal(spec)
+	if err != nil {
+		return errorCodec(err)
+	}
+	return d.encode, d.decode
+}
+
+// parseDecimal parses the P.S specification, the scale may be omitted
+func parseDecimal(spec string) (*decimal, error) {
+	p, s, _ := strings.Cut(spec, ".")
+	precision, err := strconv.Atoi(p)
+	if err != nil || precision < 1 || precision > maxDecimalPrecision {
+		return nil, fmt.Errorf("encoding: invalid decimal precision in %q", spec)
+	}
+	var scale int
+	if s != "" {
+		if scale, err = strconv.Atoi(s); err != nil || scale < 0 || scale > precision {
+			return nil, fmt.Errorf("encoding: invalid decimal scale in %q", spec)
+		}
+	}
+	return &decimal{
+		precision: precision,
+		scale:     scale,
+		limit:     new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil),
+		unit:      new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil),
+	}, nil
+}
+
+func (d *decimal) String() string {
+	return fmt.Sprintf("Decimal(%d,%d)", d.precision, d.scale)
+}
+
+// small reports whether the scaled values fit into int64 and are written as varints
+func (d *decimal) small() bool {
+	return d.precision <= 18
+}
+
+func (d *decimal) encode(enc *encode, v reflect.Value) error {
+	scaled, err := d.scaled(v)
+	if err != nil {
+		return err
+	}
+	if scaled.CmpAbs(d.limit) >= 0 {
+		return fmt.Errorf("encoding: value %s overflows %s", scaled, d)
+	}
+	if d.small() {
+		return enc.int64(scaled.Int64())
+	}
+	return enc.bigInt(scaled)
+}
+
+func (d *decimal) decode(dec *decode, v reflect.Value) error {
+	scaled := new(big.Int)
+	if d.small() {
+		value, err := dec.int64()
+		if err != nil {
+			return err
+		}
+		scaled.SetInt64(value)
+	} else if err := dec.bigInt(scaled); err != nil {
+		return err
+	}
+	if scaled.CmpAbs(d.limit) >= 0 {
+		return fmt.Errorf("encoding: value %s overflows %s", scaled, d)
+	}
+	return d.set(v, scaled)
+}
+
+// scaled returns the value multiplied by 10^scale and rounded half away from zero
+func (d *decimal) scaled(v reflect.Value) (*big.Int, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("encoding: value %v can not be stored as %s", f, d)
+		}
+		return d.round(new(big.Rat).SetFloat64(f)), nil
+	}
+	switch x := addressable(v).Addr().Interface().(type) {
+	case *big.Int:
+		return new(big.Int).Set(x), nil
+	case *big.Rat:
+		return d.round(x), nil
+	case *big.Float:
+		if x.IsInf() {
+			return nil, fmt.Errorf("encoding: value %v can not be stored as %s", x, d)
+		}
+		r, _ := x.Rat(nil)
+		return d.round(r), nil
+	}
+	return nil, fmt.Errorf("encoding: decimal option is not supported for %s", v.Type())
+}
+
+func (d *decimal) round(r *big.Rat) *big.Int {
+	var (
+		num       = new(big.Int).Mul(r.Num(), d.unit)
+		quo, rem  = new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+		remainder = rem.Abs(rem).Lsh(rem, 1)
+	)
+	if remainder.Cmp(r.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	return quo
+}
+
+// set stores the scaled value into v, integer values are stored as is
+func (d *decimal) set(v reflect.Value, scaled *big.Int) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !scaled.IsInt64() || v.OverflowInt(scaled.Int64()) {
+			return fmt.Errorf("encoding: value %s overflows %s", scaled, v.Type())
+		}
+		v.SetInt(scaled.Int64())
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !scaled.IsUint64() || v.OverflowUint(scaled.Uint64()) {
+			return fmt.Errorf("encoding: value %s overflows %s", scaled, v.Type())
+		}
+		v.SetUint(scaled.Uint64())
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, _ := new(big.Rat).SetFrac(scaled, d.unit).Float64()
+		v.SetFloat(f)
+		return nil
+	}
+	switch x := v.Addr().Interface().(type) {
+	case *big.Int:
+		x.Set(scaled)
+	case *big.Rat:
+		x.SetFrac(scaled, d.unit)
+	case *big.Float:
+		x.SetRat(new(big.Rat).SetFrac(scaled, d.unit))
+	}
+	return nil
+}
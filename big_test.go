@@ -0,0 +1,102 @@
+package encoding
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Big(t *testing.T) {
+	type T struct {
+		Int   *big.Int
+		Float *big.Float
+		Rat   *big.Rat
+		Ints  []*big.Int
+		Value big.Int
+		Nil   *big.Int
+	}
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890123456789", 10)
+	in := T{
+		Int:   huge,
+		Float: new(big.Float).SetPrec(200).SetMode(big.ToZero).Quo(big.NewFloat(1), big.NewFloat(3)),
+		Rat:   big.NewRat(-22, 7),
+		Ints:  []*big.Int{big.NewInt(0), big.NewInt(1), nil},
+		Value: *big.NewInt(42),
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, 0, in.Int.Cmp(out.Int))
+		assert.Equal(t, 0, in.Float.Cmp(out.Float))
+		assert.Equal(t, in.Float.Prec(), out.Float.Prec())
+		assert.Equal(t, in.Float.Mode(), out.Float.Mode())
+		assert.Equal(t, 0, in.Rat.Cmp(out.Rat))
+		if assert.Len(t, out.Ints, 3) {
+			assert.Equal(t, 0, out.Ints[0].Sign())
+			assert.Equal(t, int64(1), out.Ints[1].Int64())
+			assert.Nil(t, out.Ints[2])
+		}
+		assert.Equal(t, int64(42), out.Value.Int64())
+		assert.Nil(t, out.Nil)
+	}
+}
+
+func Test_Decimal(t *testing.T) {
+	type T struct {
+		Price  float64  `encoder:"price,decimal=18.4"`
+		Scaled int64    `encoder:"scaled,decimal=10.2"`
+		Rat    *big.Rat `encoder:"rat,decimal=38.10"`
+		Float  float32  `encoder:"float,decimal=9.3"`
+		Round  float64  `encoder:"round,decimal=5"`
+	}
+	in := T{
+		Price:  12.3456,
+		Scaled: -123456,
+		Rat:    big.NewRat(1, 3),
+		Float:  -1.5,
+		Round:  -2.5,
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, 12.3456, out.Price)
+		assert.Equal(t, int64(-123456), out.Scaled)
+		assert.Equal(t, "3333333333/10000000000", out.Rat.String())
+		assert.Equal(t, float32(-1.5), out.Float)
+		assert.Equal(t, float64(-3), out.Round)
+	}
+}
+
+func Test_DecimalScaledSize(t *testing.T) {
+	var buf bytes.Buffer
+	type T struct {
+		V float64 `encoder:"v,decimal=9.2"`
+	}
+	if assert.NoError(t, NewEncoder(&buf).Encode(T{V: 0.5})) {
+		// frame header, columns, column size and a single byte varint
+		assert.Equal(t, 5+1+2+4+1, buf.Len())
+	}
+}
+
+func Test_DecimalOverflow(t *testing.T) {
+	type (
+		Float struct {
+			V float64 `encoder:"v,decimal=5.2"`
+		}
+		Int struct {
+			V int64 `encoder:"v,decimal=3"`
+		}
+		Invalid struct {
+			V float64 `encoder:"v,decimal=77"`
+		}
+		Unsupported struct {
+			V string `encoder:"v,decimal=5.2"`
+		}
+	)
+	var buf bytes.Buffer
+	assert.EqualError(t, NewEncoder(&buf).Encode(Float{V: 1000}), "encoding: value 100000 overflows Decimal(5,2)")
+	assert.NoError(t, NewEncoder(&buf).Encode(Float{V: 999.99}))
+	assert.EqualError(t, NewEncoder(&buf).Encode(Int{V: -1000}), "encoding: value -1000 overflows Decimal(3,0)")
+	assert.Error(t, NewEncoder(&buf).Encode(Invalid{}))
+	assert.Error(t, NewEncoder(&buf).Encode(Unsupported{}))
+}
@@ -2,8 +2,13 @@ package encoding
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"sort"
+	"time"
 )
 
 func NewDecoder(r io.Reader) *Decoder {
@@ -13,45 +18,172 @@ func NewDecoder(r io.Reader) *Decoder {
 }
 
 type Decoder struct {
-	input   io.Reader
-	scratch [4]byte
+	input      io.Reader
+	scratch    [4]byte
+	aliasBytes bool
+	shared     sharedPointers
 }
 
+// AliasBytes makes Decode set []byte values to sub-slices of the frame instead of copying them.
+// Every frame is then read into a new buffer, which is retained by the decoded values
+func (d *Decoder) AliasBytes() {
+	d.aliasBytes = true
+}
+
+// Decode reads the next frame into the value out points to, nil pointers on the way are allocated
 func (d *Decoder) Decode(out interface{}) error {
+	value := reflect.ValueOf(out)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("encoding: Decode requires a non-nil pointer, got %T", out)
+	}
 	if _, err := io.ReadFull(d.input, d.scratch[:]); err != nil {
 		return err
 	}
-	ln := uint32(d.scratch[0]) | uint32(d.scratch[1])<<8 | uint32(d.scratch[2])<<16 | uint32(d.scratch[3])<<24
+	var (
+		ln      = uint32(d.scratch[0]) | uint32(d.scratch[1])<<8 | uint32(d.scratch[2])<<16 | uint32(d.scratch[3])<<24
+		version = uint8(formatLegacy)
+	)
+	if ln&versionedFrame != 0 {
+		if _, err := io.ReadFull(d.input, d.scratch[:1]); err != nil {
+			return err
+		}
+		if ln, version = ln&^versionedFrame, d.scratch[0]; version > formatVersion {
+			return fmt.Errorf("encoding: unsupported format version %d", version)
+		}
+	}
 	decode := decodePool.Get().(*decode)
 	decode.free()
-	if cap(decode.block) < int(ln) {
-		decode.block = make([]byte, 0, ln)
+	decode.version = version
+	decode.aliasBytes = d.aliasBytes
+	decode.shared = &d.shared
+	switch {
+	case d.aliasBytes:
+		decode.block = make([]byte, ln)
+	case cap(decode.frame) < int(ln):
+		decode.frame = make([]byte, ln)
+		fallthrough
+	default:
+		decode.block = decode.frame[:ln]
 	}
-	decode.block = decode.block[:ln]
 	if _, err := io.ReadFull(d.input, decode.block); err != nil {
+		decodePool.Put(decode)
 		return err
 	}
-	decodeStruct(decode, reflect.ValueOf(out).Elem())
+	ptr := value
+	for value = value.Elem(); value.Kind() == reflect.Ptr; value = value.Elem() {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		ptr = value
+	}
+	// The value starts the frame, as in Encoder.Encode
+	d.shared.frame, d.shared.root = decode.block, ptr
+	err := getDecodeFunc(value.Type())(decode, value)
+	d.shared.reset()
 	decodePool.Put(decode)
-	return nil
+	return err
+}
+
+// sharedPointers are the pointees of a frame written by Encoder.SharePointers, by their offset in the frame
+type sharedPointers struct {
+	frame  []byte
+	root   reflect.Value
+	values map[int]reflect.Value
+}
+
+func (p *sharedPointers) get(offset int) (reflect.Value, bool) {
+	if offset == 0 {
+		return p.root, p.root.IsValid()
+	}
+	v, found := p.values[offset]
+	return v, found
+}
+
+func (p *sharedPointers) set(offset int, v reflect.Value) {
+	if p.values == nil {
+		p.values = make(map[int]reflect.Value)
+	}
+	p.values[offset] = v
+}
+
+func (p *sharedPointers) reset() {
+	for offset := range p.values {
+		delete(p.values, offset)
+	}
+	p.frame, p.root = nil, reflect.Value{}
 }
 
 type decode struct {
-	block   []byte
-	offset  int
-	columns columns
+	// frame is the buffer reused for the frames read by Decoder,
+	// block is the part of the frame being decoded and base is its offset in the frame
+	frame      []byte
+	block      []byte
+	base       int
+	offset     int
+	version    uint8
+	aliasBytes bool
+	shared     *sharedPointers
+	columns    columns
 }
 
 func (decode *decode) free() {
-	decode.block = decode.block[0:0]
+	decode.block = nil
+	decode.shared = nil
+	decode.base = 0
 	decode.offset = 0
 	decode.columns = decode.columns[0:0]
 }
 
+// readColumns reads the table of columns written by encode.writeColumns, sorted by name
+func (decode *decode) readColumns() (columns, error) {
+	fLen, err := decode.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if fLen > uint64(decode.remaining()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if cap(decode.columns) < int(fLen) {
+		decode.columns = make(columns, fLen)
+	}
+	columns := decode.columns[:fLen]
+	for i := 0; i < int(fLen); i++ {
+		name, err := decode.string()
+		if err != nil {
+			return nil, err
+		}
+		columns[i].name = name
+	}
+
+	for i := 0; i < int(fLen); i++ {
+		size, err := decode.uint32()
+		if err != nil {
+			return nil, err
+		}
+		columns[i].size = int(size)
+	}
+
+	for i, column := range columns {
+		columns[i].offset = decode.base + decode.offset
+		block, err := decode.readFixed(column.size)
+		if err != nil {
+			return nil, err
+		}
+		columns[i].block = block
+	}
+
+	sort.Sort(columns)
+	return columns, nil
+}
+
 func (decode *decode) uvarint() (uint64, error) {
 	return binary.ReadUvarint(decode)
 }
 
+func (decode *decode) int64() (int64, error) {
+	return binary.ReadVarint(decode)
+}
+
 func (decode *decode) uint8() (uint8, error) {
 	byte, err := decode.ReadByte()
 	if err != nil {
@@ -60,6 +192,28 @@ func (decode *decode) uint8() (uint8, error) {
 	return uint8(byte), nil
 }
 
+func (decode *decode) bool() (bool, error) {
+	v, err := decode.uint8()
+	if err != nil {
+		return false, err
+	}
+	switch v {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, fmt.Errorf("encoding: invalid bool value %d", v)
+}
+
+func (decode *decode) uint16() (uint16, error) {
+	b, err := decode.readFixed(2)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(b[0]) | uint16(b[1])<<8, nil
+}
+
 func (decode *decode) uint32() (uint32, error) {
 	b, err := decode.readFixed(4)
 	if err != nil {
@@ -69,33 +223,149 @@ func (decode *decode) uint32() (uint32, error) {
 }
 
 func (decode *decode) uint64() (uint64, error) {
-	b, err := decode.readFixed(6)
+	if decode.version == formatLegacy {
+		b, err := decode.readFixed(6)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 | uint64(b[4])<<48 | uint64(b[5])<<56, nil
+	}
+	b, err := decode.readFixed(8)
 	if err != nil {
 		return 0, err
 	}
-	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 | uint64(b[4])<<48 | uint64(b[5])<<56, nil
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56, nil
 }
 
-func (decode *decode) string() (string, error) {
-	strlen, err := decode.uvarint()
+func (decode *decode) float32() (float32, error) {
+	v, err := decode.uint32()
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+	return math.Float32frombits(v), nil
+}
+
+// float64 always reads 8 bytes, floats have never been written in the legacy layout
+func (decode *decode) float64() (float64, error) {
+	b, err := decode.readFixed(8)
+	if err != nil {
+		return 0, err
+	}
+	var bits uint64
+	for i := 0; i < 8; i++ {
+		bits |= uint64(b[i]) << (8 * uint(i))
 	}
-	bytes, err := decode.readFixed(int(strlen))
+	return math.Float64frombits(bits), nil
+}
+
+func (decode *decode) complex64() (complex64, error) {
+	re, err := decode.float32()
+	if err != nil {
+		return 0, err
+	}
+	im, err := decode.float32()
+	if err != nil {
+		return 0, err
+	}
+	return complex(re, im), nil
+}
+
+func (decode *decode) complex128() (complex128, error) {
+	re, err := decode.float64()
+	if err != nil {
+		return 0, err
+	}
+	im, err := decode.float64()
+	if err != nil {
+		return 0, err
+	}
+	return complex(re, im), nil
+}
+
+func (decode *decode) time() (time.Time, error) {
+	sec, err := decode.int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	nsec, err := decode.uvarint()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nsec >= uint64(time.Second) {
+		return time.Time{}, fmt.Errorf("encoding: invalid time nanoseconds %d", nsec)
+	}
+	offset, err := decode.int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	name, err := decode.string()
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := time.Unix(sec, int64(nsec))
+	if name == "UTC" && offset == 0 {
+		return t.UTC(), nil
+	}
+	return t.In(time.FixedZone(name, int(offset))), nil
+}
+
+func (decode *decode) bigInt(v *big.Int) error {
+	sign, err := decode.uint8()
+	if err != nil {
+		return err
+	}
+	if sign > 1 {
+		return fmt.Errorf("encoding: invalid big.Int sign %d", sign)
+	}
+	abs, err := decode.bytes()
+	if err != nil {
+		return err
+	}
+	if v.SetBytes(abs); sign == 1 {
+		v.Neg(v)
+	}
+	return nil
+}
+
+// bytes returns a length-prefixed byte string, the result refers to the block
+func (decode *decode) bytes() ([]byte, error) {
+	ln, err := decode.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if ln > uint64(decode.remaining()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return decode.readFixed(int(ln))
+}
+
+func (decode *decode) string() (string, error) {
+	bytes, err := decode.bytes()
 	if err != nil {
 		return "", err
 	}
 	return string(bytes), nil
 }
 
+func (decode *decode) remaining() int {
+	return len(decode.block) - decode.offset
+}
+
 func (decode *decode) readFixed(ln int) ([]byte, error) {
 	idx := decode.offset
+	if ln < 0 || ln > len(decode.block)-idx {
+		return nil, io.ErrUnexpectedEOF
+	}
 	decode.offset = idx + ln
 	return decode.block[idx : idx+ln], nil
 }
 
 func (decode *decode) ReadByte() (byte, error) {
 	idx := decode.offset
+	if idx >= len(decode.block) {
+		return 0, io.ErrUnexpectedEOF
+	}
 	decode.offset++
 	return decode.block[idx], nil
 }
@@ -1,37 +1,149 @@
 package encoding
 
 import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"reflect"
 	"sort"
 	"sync"
+	"unsafe"
 )
 
 type decodeFunc func(decode *decode, v reflect.Value) error
 
-var decodeFuncMap map[reflect.Kind]decodeFunc
+var (
+	decodeFuncMap map[reflect.Kind]decodeFunc
+	// decodeTypeMap holds the codecs of the types that are not decoded by their kind
+	decodeTypeMap map[reflect.Type]decodeFunc
+)
 
 func init() {
+	typeDecodeFuncs.funcs = make(map[reflect.Type]decodeFunc)
+	decodeTypeMap = map[reflect.Type]decodeFunc{
+		timeType:        decodeTime,
+		durationType:    decodeInt,
+		bigIntType:      decodeBigInt,
+		bigFloatType:    decodeBigFloat,
+		bigRatType:      decodeBigRat,
+		netipAddrType:   decodeNetipAddr,
+		netipPrefixType: decodeNetipPrefix,
+		netIPType:       decodeNetIP,
+	}
 	decodeFuncMap = map[reflect.Kind]decodeFunc{
-		reflect.Struct: decodeStruct,
-		reflect.String: decodeString,
-		reflect.Uint32: decodeUInt32,
-		reflect.Uint64: decodeUInt64,
+		reflect.Struct:     decodeStruct,
+		reflect.String:     decodeString,
+		reflect.Bool:       decodeBool,
+		reflect.Int:        decodeInt,
+		reflect.Int8:       decodeInt,
+		reflect.Int16:      decodeInt,
+		reflect.Int32:      decodeInt,
+		reflect.Int64:      decodeInt,
+		reflect.Uint:       decodeUVarint,
+		reflect.Uint8:      decodeUInt8,
+		reflect.Uint16:     decodeUInt16,
+		reflect.Uint32:     decodeUInt32,
+		reflect.Uint64:     decodeUInt64,
+		reflect.Uintptr:    decodeUVarint,
+		reflect.Float32:    decodeFloat32,
+		reflect.Float64:    decodeFloat64,
+		reflect.Complex64:  decodeComplex64,
+		reflect.Complex128: decodeComplex128,
+		reflect.Interface:  decodeInterface,
+	}
+}
+
+// typeDecodeFuncs caches typeDecodeFunc like typeEncodeFuncs
+var typeDecodeFuncs struct {
+	mutex sync.RWMutex
+	funcs map[reflect.Type]decodeFunc
+}
+
+// typeDecodeFunc returns the codec of the types that are not decoded by their kind, or nil
+func typeDecodeFunc(t reflect.Type) decodeFunc {
+	typeDecodeFuncs.mutex.RLock()
+	fn, ok := typeDecodeFuncs.funcs[t]
+	typeDecodeFuncs.mutex.RUnlock()
+	if !ok {
+		fn = newTypeDecodeFunc(t)
+		typeDecodeFuncs.mutex.Lock()
+		typeDecodeFuncs.funcs[t] = fn
+		typeDecodeFuncs.mutex.Unlock()
 	}
+	return fn
 }
 
-func getDecodeFunc(k reflect.Kind) decodeFunc {
-	if fn, ok := decodeFuncMap[k]; ok {
+func newTypeDecodeFunc(t reflect.Type) decodeFunc {
+	if fn, ok := decodeTypeMap[t]; ok {
 		return fn
 	}
-	return func(decode *decode, v reflect.Value) error {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return ptrDecodeFunc(lazyDecodeFunc(t.Elem()))
+	case reflect.Interface:
+		return nil
+	}
+	if promotedMarshaler(t) {
 		return nil
 	}
+	if err := marshalerError(t); err != nil {
+		_, fn := errorCodec(err)
+		return fn
+	}
+	switch {
+	case reflect.PointerTo(t).Implements(unmarshalerType):
+		return decodeUnmarshaler
+	case nullable(t):
+		return nullableDecodeFunc(t)
+	}
+	if fn := blobDecodeFunc(t); fn != nil {
+		return fn
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		return newSliceDecodeFunc(t)
+	case reflect.Array:
+		return newArrayDecodeFunc(t)
+	case reflect.Map:
+		return newMapDecodeFunc(t)
+	}
+	return nil
+}
+
+func getDecodeFunc(t reflect.Type) decodeFunc {
+	if fn := typeDecodeFunc(t); fn != nil {
+		return fn
+	}
+	if fn, ok := decodeFuncMap[t.Kind()]; ok {
+		return fn
+	}
+	return decodeNothing
+}
+
+// lazyDecodeFunc returns a decoder which looks up the decoder of t when it is first called
+func lazyDecodeFunc(t reflect.Type) decodeFunc {
+	var (
+		once sync.Once
+		fn   decodeFunc
+	)
+	return func(d *decode, v reflect.Value) error {
+		once.Do(func() { fn = getDecodeFunc(t) })
+		return fn(d, v)
+	}
+}
+
+// decodeNothing is the decoder of the kinds which are not written
+func decodeNothing(decode *decode, v reflect.Value) error {
+	return nil
 }
 
 type column struct {
 	name  string
 	size  int
 	block []byte
+	// offset is the offset of the block in the frame
+	offset int
 }
 
 type columns []column
@@ -40,64 +152,176 @@ func (a columns) Len() int           { return len(a) }
 func (a columns) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a columns) Less(i, j int) bool { return a[i].name < a[j].name }
 
+// find looks up a column by name in the columns sorted by decode.readColumns
+func (a columns) find(name string) (column, bool) {
+	if i := sort.Search(len(a), func(i int) bool { return a[i].name >= name }); i < len(a) && a[i].name == name {
+		return a[i], true
+	}
+	return column{}, false
+}
+
 var decodePool = sync.Pool{
 	New: func() interface{} {
 		return &decode{
-			block:   make([]byte, 0, 100),
+			frame:   make([]byte, 0, 100),
 			columns: make(columns, 0, 25),
 		}
 	},
 }
 
 func decodeStruct(d *decode, v reflect.Value) error {
-	fLen, err := d.uvarint()
+	columns, err := d.readColumns()
 	if err != nil {
 		return err
 	}
-	if cap(d.columns) < int(fLen) {
-		d.columns = make(columns, fLen)
-	}
-	columns := d.columns[:fLen]
-	for i := 0; i < int(fLen); i++ {
-		name, err := d.string()
+	for _, field := range fields(v.Type()) {
+		var (
+			column, found = columns.find(field.name)
+			fn            = field.decode
+		)
+		if d.version == formatLegacy {
+			if fn = legacyDecodeFunc(field.typ); len(column.block) == 0 {
+				found = false
+			}
+		}
+		if !found || fn == nil {
+			if err := field.missing(v); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := field.settable(v)
 		if err != nil {
 			return err
 		}
-		columns[i].name = name
-	}
-
-	for i := 0; i < int(fLen); i++ {
-		size, err := d.uint32()
+		decode := subDecode(d, column.block, column.offset)
+		err = fn(decode, value)
+		decodePool.Put(decode)
 		if err != nil {
 			return err
 		}
-		columns[i].size = int(size)
 	}
+	return nil
+}
+
+// legacyDecodeFunc returns the decoder of the fields of type t in legacy frames, or nil if the
+// column has no value: the encoder of those frames wrote strings, uint32, uint64 and the exported
+// fields of structs by their kind, and empty columns or structs for everything else
+func legacyDecodeFunc(t reflect.Type) decodeFunc {
+	switch t.Kind() {
+	case reflect.String:
+		return decodeString
+	case reflect.Uint32:
+		return decodeUInt32
+	case reflect.Uint64:
+		return decodeUInt64
+	case reflect.Struct:
+		if typeDecodeFunc(t) == nil {
+			return decodeStruct
+		}
+	}
+	return nil
+}
 
-	for i, column := range columns {
-		block, err := d.readFixed(column.size)
+// decodeStructSlice reads a slice of structs written column-wise by encodeStructSlice
+func decodeStructSlice(d *decode, v reflect.Value) error {
+	ln, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if ln == 0 {
+		makeSlice(v, 0)
+		return nil
+	}
+	columns, err := d.readColumns()
+	if err != nil {
+		return err
+	}
+	// Each element but a zero-size one takes at least a byte, either of the columns or of the padding
+	// written when the columns take no space
+	var size int
+	for _, column := range columns {
+		size += column.size
+	}
+	elem := v.Type().Elem()
+	switch {
+	case size != 0:
+		if ln > uint64(size) {
+			return io.ErrUnexpectedEOF
+		}
+	case elem.Size() != 0:
+		if ln > uint64(d.remaining()) {
+			return io.ErrUnexpectedEOF
+		}
+		if _, err := d.readFixed(int(ln)); err != nil {
+			return err
+		}
+	}
+	makeSlice(v, int(ln))
+	zero := reflect.Zero(elem)
+	for i := 0; i < int(ln); i++ {
+		v.Index(i).Set(zero)
+	}
+	for _, field := range fields(elem) {
+		column, found := columns.find(field.name)
+		if !found {
+			for i := 0; i < int(ln) && err == nil; i++ {
+				err = field.missing(v.Index(i))
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		decode := subDecode(d, column.block, column.offset)
+		for i := 0; i < int(ln) && err == nil; i++ {
+			var value reflect.Value
+			if value, err = field.settable(v.Index(i)); err == nil {
+				err = field.decode(decode, value)
+			}
+		}
+		decodePool.Put(decode)
 		if err != nil {
 			return err
 		}
-		columns[i].block = block
 	}
-
-	sort.Sort(columns)
-
-	for i, field := range fields(v.Type()) {
-		f := sort.Search(len(columns), func(i int) bool { return columns[i].name >= field.name })
-		if f < len(columns) && columns[f].name == field.name {
-			decode := decodePool.Get().(*decode)
-			decode.free()
-			decode.block = append(decode.block, columns[f].block...)
-			field.decode(decode, v.Field(i))
-			decodePool.Put(decode)
+	// The fields promoted through an embedded pointer were decoded into every element,
+	// the presence column tells in which of them the pointer is nil
+	for _, pointer := range embeddedPointers(elem) {
+		column, found := columns.find(pointer.name)
+		if !found {
+			continue
+		}
+		decode := subDecode(d, column.block, column.offset)
+		for i := 0; i < int(ln) && err == nil; i++ {
+			var set bool
+			if set, err = decode.bool(); err == nil && !set {
+				if ptr, ok := pointer.value(v.Index(i)); ok && !ptr.IsNil() {
+					ptr.SetZero()
+				}
+			}
+		}
+		decodePool.Put(decode)
+		if err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
+// subDecode returns a pooled decode over block at the offset base of the frame,
+// the caller puts it back to decodePool
+func subDecode(d *decode, block []byte, base int) *decode {
+	sub := decodePool.Get().(*decode)
+	sub.free()
+	sub.version = d.version
+	sub.aliasBytes = d.aliasBytes
+	sub.shared = d.shared
+	sub.block = block
+	sub.base = base
+	return sub
+}
+
 func decodeString(d *decode, v reflect.Value) error {
 	str, err := d.string()
 	if err != nil {
@@ -107,6 +331,54 @@ func decodeString(d *decode, v reflect.Value) error {
 	return nil
 }
 
+func decodeTime(d *decode, v reflect.Value) error {
+	value, err := d.time()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(value))
+	return nil
+}
+
+func decodeBool(d *decode, v reflect.Value) error {
+	value, err := d.bool()
+	if err != nil {
+		return err
+	}
+	v.SetBool(value)
+	return nil
+}
+
+func decodeInt(d *decode, v reflect.Value) error {
+	value, err := d.int64()
+	if err != nil {
+		return err
+	}
+	if v.OverflowInt(value) {
+		return fmt.Errorf("encoding: value %d overflows %s", value, v.Type())
+	}
+	v.SetInt(value)
+	return nil
+}
+
+func decodeUInt8(d *decode, v reflect.Value) error {
+	value, err := d.uint8()
+	if err != nil {
+		return err
+	}
+	v.SetUint(uint64(value))
+	return nil
+}
+
+func decodeUInt16(d *decode, v reflect.Value) error {
+	value, err := d.uint16()
+	if err != nil {
+		return err
+	}
+	v.SetUint(uint64(value))
+	return nil
+}
+
 func decodeUInt32(d *decode, v reflect.Value) error {
 	value, err := d.uint32()
 	if err != nil {
@@ -124,3 +396,406 @@ func decodeUInt64(d *decode, v reflect.Value) error {
 	v.SetUint(value)
 	return nil
 }
+
+func decodeComplex64(d *decode, v reflect.Value) error {
+	value, err := d.complex64()
+	if err != nil {
+		return err
+	}
+	*(*complex64)(unsafe.Pointer(v.UnsafeAddr())) = value
+	return nil
+}
+
+func decodeComplex128(d *decode, v reflect.Value) error {
+	value, err := d.complex128()
+	if err != nil {
+		return err
+	}
+	v.SetComplex(value)
+	return nil
+}
+
+func decodeUVarint(d *decode, v reflect.Value) error {
+	value, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if v.OverflowUint(value) {
+		return fmt.Errorf("encoding: value %d overflows %s", value, v.Type())
+	}
+	v.SetUint(value)
+	return nil
+}
+
+func decodeFloat32(d *decode, v reflect.Value) error {
+	value, err := d.float32()
+	if err != nil {
+		return err
+	}
+	// v.SetFloat would round-trip the value through float64 and quiet signaling NaNs
+	*(*float32)(unsafe.Pointer(v.UnsafeAddr())) = value
+	return nil
+}
+
+func decodeFloat64(d *decode, v reflect.Value) error {
+	value, err := d.float64()
+	if err != nil {
+		return err
+	}
+	v.SetFloat(value)
+	return nil
+}
+
+// newSliceDecodeFunc returns the codec of the slices of type t, like newSliceEncodeFunc
+func newSliceDecodeFunc(t reflect.Type) decodeFunc {
+	elem := t.Elem()
+	switch {
+	case columnar(elem):
+		return decodeStructSlice
+	case elem.Kind() == reflect.Uint8 && typeDecodeFunc(elem) == nil:
+		return decodeBytes
+	}
+	if size := unpackedSize(elem); size != 0 {
+		kind := elem.Kind()
+		return func(d *decode, v reflect.Value) error {
+			ln, err := d.uvarint()
+			if err != nil {
+				return err
+			}
+			if ln > uint64(d.remaining()/size) {
+				return io.ErrUnexpectedEOF
+			}
+			block, err := d.readFixed(int(ln) * size)
+			if err != nil {
+				return err
+			}
+			if makeSlice(v, int(ln)); ln == 0 {
+				return nil
+			}
+			return decodeFixed(block, kind, v.UnsafePointer(), int(ln))
+		}
+	}
+	var (
+		zero  = reflect.Zero(elem)
+		empty = elem.Size() == 0
+		fn    = lazyDecodeFunc(elem)
+	)
+	return func(d *decode, v reflect.Value) error {
+		ln, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		// Every element but a zero-size one takes at least a byte, don't let a corrupted length allocate more
+		if ln > uint64(d.remaining()) && !empty {
+			return io.ErrUnexpectedEOF
+		}
+		makeSlice(v, int(ln))
+		for i := 0; i < int(ln); i++ {
+			item := v.Index(i)
+			item.Set(zero)
+			if err := fn(d, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// unpackedSize returns the width of the elements of type t which are read in bulk, like packedSize
+func unpackedSize(t reflect.Type) int {
+	size := fixedSize(t.Kind())
+	if size == 0 || typeDecodeFunc(t) != nil {
+		return 0
+	}
+	return size
+}
+
+// decodeBytes copies a byte slice out of the block or, with Decoder.AliasBytes, refers to it
+func decodeBytes(d *decode, v reflect.Value) error {
+	bytes, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(bytes) == 0:
+		v.Set(reflect.Zero(v.Type()))
+	case d.aliasBytes:
+		v.SetBytes(bytes)
+	default:
+		makeSlice(v, len(bytes))
+		copy(v.Bytes(), bytes)
+	}
+	return nil
+}
+
+// newArrayDecodeFunc returns the codec of the arrays of type t, like newArrayEncodeFunc
+func newArrayDecodeFunc(t reflect.Type) decodeFunc {
+	var (
+		ln   = t.Len()
+		elem = t.Elem()
+	)
+	if ln == 0 {
+		return decodeNothing
+	}
+	if size := unpackedSize(elem); size != 0 {
+		kind := elem.Kind()
+		return func(d *decode, v reflect.Value) error {
+			block, err := d.readFixed(size * ln)
+			if err != nil {
+				return err
+			}
+			return decodeFixed(block, kind, unsafe.Pointer(v.UnsafeAddr()), ln)
+		}
+	}
+	var (
+		zero = reflect.Zero(elem)
+		fn   = lazyDecodeFunc(elem)
+	)
+	return func(d *decode, v reflect.Value) error {
+		for i := 0; i < ln; i++ {
+			item := v.Index(i)
+			item.Set(zero)
+			if err := fn(d, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// makeSlice sets the length of v to ln, reusing its backing array when it is large enough
+func makeSlice(v reflect.Value, ln int) {
+	switch {
+	case ln == 0:
+		v.Set(reflect.Zero(v.Type()))
+	case v.Cap() >= ln:
+		v.SetLen(ln)
+	default:
+		v.Set(reflect.MakeSlice(v.Type(), ln, ln))
+	}
+}
+
+// decodeFixed unpacks ln elements of a fixed width kind from block into the memory at ptr
+func decodeFixed(block []byte, k reflect.Kind, ptr unsafe.Pointer, ln int) error {
+	switch k {
+	case reflect.Bool:
+		for _, b := range block {
+			if b > 1 {
+				return fmt.Errorf("encoding: invalid bool value %d", b)
+			}
+		}
+		copy(unsafe.Slice((*byte)(ptr), ln), block)
+	case reflect.Uint8:
+		copy(unsafe.Slice((*byte)(ptr), ln), block)
+	case reflect.Uint16:
+		values := unsafe.Slice((*uint16)(ptr), ln)
+		for i := range values {
+			values[i] = binary.LittleEndian.Uint16(block[2*i:])
+		}
+	case reflect.Uint32, reflect.Float32, reflect.Complex64:
+		values := unsafe.Slice((*uint32)(ptr), len(block)/4)
+		for i := range values {
+			values[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+	case reflect.Uint64, reflect.Float64, reflect.Complex128:
+		values := unsafe.Slice((*uint64)(ptr), len(block)/8)
+		for i := range values {
+			values[i] = binary.LittleEndian.Uint64(block[8*i:])
+		}
+	}
+	return nil
+}
+
+// decodePtrWith sets v to nil or to a newly allocated value, depending on the presence marker
+func decodePtrWith(d *decode, v reflect.Value, decode decodeFunc) error {
+	marker, err := d.uint8()
+	if err != nil {
+		return err
+	}
+	switch marker {
+	case ptrNil:
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	case ptrValue:
+		ptr := reflect.New(v.Type().Elem())
+		if err := decode(d, ptr.Elem()); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	case ptrShared:
+		size, err := d.uint32()
+		if err != nil {
+			return err
+		}
+		offset := d.base + d.offset
+		block, err := d.readFixed(int(size))
+		if err != nil {
+			return err
+		}
+		return decodeShared(d, v, offset, block, decode)
+	case ptrRef:
+		ref, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		frame := d.shared.frame
+		if ref > uint64(len(frame)) {
+			return fmt.Errorf("encoding: invalid pointer reference %d", ref)
+		}
+		var (
+			offset = int(ref)
+			block  []byte
+		)
+		if _, found := d.shared.get(offset); !found && offset >= 4 {
+			// The pointee is in a column which has not been decoded yet, its size precedes it
+			if size := int(binary.LittleEndian.Uint32(frame[offset-4:])); size <= len(frame)-offset {
+				block = frame[offset : offset+size]
+			}
+		}
+		return decodeShared(d, v, offset, block, decode)
+	}
+	return fmt.Errorf("encoding: invalid pointer marker %d", marker)
+}
+
+// decodeShared sets v to the pointee written at offset in the frame, which is read from block
+// unless it has been decoded already
+func decodeShared(d *decode, v reflect.Value, offset int, block []byte, fn decodeFunc) error {
+	if ptr, found := d.shared.get(offset); found {
+		if ptr.Type() != v.Type() {
+			return fmt.Errorf("encoding: pointer reference %d is not a %s", offset, v.Type())
+		}
+		v.Set(ptr)
+		return nil
+	}
+	if block == nil {
+		return fmt.Errorf("encoding: invalid pointer reference %d", offset)
+	}
+	ptr := reflect.New(v.Type().Elem())
+	// The pointee is known before it is decoded, for the cycles through it
+	d.shared.set(offset, ptr)
+	sub := subDecode(d, block, offset)
+	err := fn(sub, ptr.Elem())
+	decodePool.Put(sub)
+	if err != nil {
+		return err
+	}
+	v.Set(ptr)
+	return nil
+}
+
+// ptrDecodeFunc returns the decoder of pointers to the values read by fn
+func ptrDecodeFunc(fn decodeFunc) decodeFunc {
+	return func(d *decode, v reflect.Value) error {
+		return decodePtrWith(d, v, fn)
+	}
+}
+
+// decodeInterface allocates a value of the registered type and sets v to it
+func decodeInterface(d *decode, v reflect.Value) error {
+	if variants, ok := unionVariants(v.Type()); ok {
+		return decodeUnion(d, v, variants)
+	}
+	name, err := d.string()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	t, err := registeredType(name)
+	if err != nil {
+		return err
+	}
+	if !t.AssignableTo(v.Type()) {
+		return fmt.Errorf("encoding: type %s registered as %q does not implement %s", t, name, v.Type())
+	}
+	value := reflect.New(t).Elem()
+	if err := getDecodeFunc(t)(d, value); err != nil {
+		return err
+	}
+	v.Set(value)
+	return nil
+}
+
+// decodeUnion allocates the variant written by encodeUnion and sets v to it
+func decodeUnion(d *decode, v reflect.Value, variants []reflect.Type) error {
+	i, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	switch {
+	case i == 0:
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	case i > uint64(len(variants)):
+		return fmt.Errorf("encoding: invalid variant %d of %s", i, v.Type())
+	}
+	t := variants[i-1]
+	value := reflect.New(t).Elem()
+	if err := getDecodeFunc(t)(d, value); err != nil {
+		return err
+	}
+	v.Set(value)
+	return nil
+}
+
+func newMapDecodeFunc(t reflect.Type) decodeFunc {
+	m := mapDecoder{
+		textKey:     isTextKey(t.Key()),
+		decodeKey:   lazyDecodeFunc(t.Key()),
+		decodeValue: lazyDecodeFunc(t.Elem()),
+	}
+	return m.decode
+}
+
+// mapDecoder reads the entries written by mapEncoder, an existing map is cleared and reused
+type mapDecoder struct {
+	textKey     bool
+	decodeKey   decodeFunc
+	decodeValue decodeFunc
+}
+
+func (m *mapDecoder) decode(d *decode, v reflect.Value) error {
+	ln, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if ln > uint64(d.remaining()) {
+		return io.ErrUnexpectedEOF
+	}
+	if v.IsNil() {
+		if ln == 0 {
+			return nil
+		}
+		v.Set(reflect.MakeMapWithSize(v.Type(), int(ln)))
+	} else {
+		v.Clear()
+	}
+	var (
+		t     = v.Type()
+		key   = reflect.New(t.Key()).Elem()
+		value = reflect.New(t.Elem()).Elem()
+	)
+	for i := 0; i < int(ln); i++ {
+		key.Set(reflect.Zero(t.Key()))
+		if m.textKey {
+			text, err := d.string()
+			if err != nil {
+				return err
+			}
+			if err := key.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text)); err != nil {
+				return err
+			}
+		} else if err := m.decodeKey(d, key); err != nil {
+			return err
+		}
+		value.Set(reflect.Zero(t.Elem()))
+		if err := m.decodeValue(d, value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, value)
+	}
+	return nil
+}
@@ -2,11 +2,25 @@ package encoding
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"time"
 	"unsafe"
 )
 
+const (
+	// formatLegacy is the implied version of frames without a version byte,
+	// they store uint64 values in 6 bytes and lose bits 32-47
+	formatLegacy = 0
+	// formatVersion is the version of the frames written by Encoder
+	formatVersion = 1
+	// versionedFrame is set in the frame length when a version byte follows it
+	versionedFrame = 1 << 31
+)
+
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{
 		out: w,
@@ -21,51 +35,138 @@ type Encoder struct {
 	encode *encode
 }
 
+// SharePointers makes Encode write a pointer seen earlier in the frame as a reference to it,
+// so Decoder rebuilds shared and cyclic structures whichever columns it decodes.
+// Without it a cycle is an error, as is a cycle through maps and slices in any case
+func (e *Encoder) SharePointers() {
+	e.encode.sharing = true
+}
+
+// Encode writes v as a frame, pointers are followed down to the value they point to
 func (e *Encoder) Encode(v interface{}) error {
-	value := reflect.ValueOf(v)
-	if value.Kind() == reflect.Ptr {
-		value = value.Elem()
-	}
-	switch value.Kind() {
-	case reflect.Struct:
-		if err := encodeStruct(e.encode, value); err != nil {
-			return err
-		}
-	default:
-		if err := e.base(v); err != nil {
-			return err
-		}
+	var (
+		value = reflect.ValueOf(v)
+		ptr   reflect.Value
+	)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		ptr, value = value, value.Elem()
+	}
+	if !value.IsValid() || value.Kind() == reflect.Ptr {
+		return fmt.Errorf("encoding: can not encode nil value")
+	}
+	if ptr.IsValid() {
+		// The value starts the frame, the references to the pointer point there
+		e.encode.share(ptr, 0)
+	} else {
+		// The codecs read floats and packed arrays through their address, a single copy
+		// of the value makes all of its fields addressable
+		value = addressable(value)
+	}
+	err := getEncodeFunc(value.Type())(e.encode, value)
+	e.encode.reset()
+	if err != nil {
+		e.encode.buf.free()
+		return err
 	}
 	return e.write()
 }
 
 func (e *Encoder) write() error {
-	v := uint32(e.encode.buf.len())
+	ln := e.encode.buf.len()
+	if uint64(ln) >= versionedFrame {
+		e.encode.buf.free()
+		return fmt.Errorf("encoding: frame size %d exceeds the limit", ln)
+	}
+	v := uint32(ln) | versionedFrame
 	{
 		e.encode.scratch[0] = byte(v)
 		e.encode.scratch[1] = byte(v >> 8)
 		e.encode.scratch[2] = byte(v >> 16)
 		e.encode.scratch[3] = byte(v >> 24)
+		e.encode.scratch[4] = formatVersion
 	}
-	if _, err := e.out.Write(e.encode.scratch[:4]); err != nil {
+	if _, err := e.out.Write(e.encode.scratch[:5]); err != nil {
 		return err
 	}
 	return e.encode.buf.writeTo(e.out)
 }
 
-func (e *Encoder) base(v interface{}) error {
-	switch value := v.(type) {
-	case uint8:
-		return e.encode.uint8(value)
-	case string:
-		return e.encode.string(value)
+type encode struct {
+	buf     *buffer
+	scratch [binary.MaxVarintLen64]byte
+	// pointers holds the offsets in the frame of the pointees written when sharing is on,
+	// visiting holds the pointers, maps and slices being written once the nesting exceeds cycleDepth
+	sharing  bool
+	pointers map[pointerKey]int
+	visiting map[pointerKey]struct{}
+	depth    int
+}
+
+// pointerKey identifies a pointee, the type tells apart a struct from its first field
+// and the length a slice from its prefix
+type pointerKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func newPointerKey(v reflect.Value) pointerKey {
+	key := pointerKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
 	}
+	return key
+}
+
+// share records that the pointee of ptr is written at offset when sharing is on. The pointees
+// without size are left out, as distinct zero-size values may have the same address
+func (enc *encode) share(ptr reflect.Value, offset int) {
+	if !enc.sharing || ptr.Type().Elem().Size() == 0 {
+		return
+	}
+	if enc.pointers == nil {
+		enc.pointers = make(map[pointerKey]int)
+	}
+	enc.pointers[newPointerKey(ptr)] = offset
+}
+
+// enter marks v as being written. Once the nesting exceeds cycleDepth the pointers, maps and slices
+// being written are tracked, so that a cycle is reported instead of recursing forever
+func (enc *encode) enter(v reflect.Value) error {
+	if enc.depth++; enc.depth <= cycleDepth {
+		return nil
+	}
+	key := newPointerKey(v)
+	if _, found := enc.visiting[key]; found {
+		enc.depth--
+		if v.Kind() == reflect.Ptr {
+			return fmt.Errorf("encoding: cycle through %s, use Encoder.SharePointers to encode it", v.Type())
+		}
+		return fmt.Errorf("encoding: cycle through %s", v.Type())
+	}
+	if enc.visiting == nil {
+		enc.visiting = make(map[pointerKey]struct{})
+	}
+	enc.visiting[key] = struct{}{}
 	return nil
 }
 
-type encode struct {
-	buf     *buffer
-	scratch [binary.MaxVarintLen64]byte
+// leave is called when v marked by enter is written
+func (enc *encode) leave(v reflect.Value) {
+	if enc.depth--; enc.depth >= cycleDepth {
+		delete(enc.visiting, newPointerKey(v))
+	}
+}
+
+// reset forgets the pointers of the frame
+func (enc *encode) reset() {
+	for k := range enc.pointers {
+		delete(enc.pointers, k)
+	}
+	for k := range enc.visiting {
+		delete(enc.visiting, k)
+	}
+	enc.depth = 0
 }
 
 func (enc *encode) bool(v bool) error {
@@ -108,16 +209,45 @@ func (enc *encode) uint64(v uint64) error {
 	enc.scratch[1] = byte(v >> 8)
 	enc.scratch[2] = byte(v >> 16)
 	enc.scratch[3] = byte(v >> 24)
-	enc.scratch[4] = byte(v >> 48)
-	enc.scratch[5] = byte(v >> 56)
-	if _, err := enc.buf.Write(enc.scratch[:6]); err != nil {
+	enc.scratch[4] = byte(v >> 32)
+	enc.scratch[5] = byte(v >> 40)
+	enc.scratch[6] = byte(v >> 48)
+	enc.scratch[7] = byte(v >> 56)
+	if _, err := enc.buf.Write(enc.scratch[:8]); err != nil {
 		return err
 	}
 	return nil
 }
 
+func (enc *encode) float32(v float32) error {
+	return enc.uint32(math.Float32bits(v))
+}
+
+func (enc *encode) float64(v float64) error {
+	return enc.uint64(math.Float64bits(v))
+}
+
+func (enc *encode) complex64(v complex64) error {
+	if err := enc.float32(real(v)); err != nil {
+		return err
+	}
+	return enc.float32(imag(v))
+}
+
+func (enc *encode) complex128(v complex128) error {
+	if err := enc.float64(real(v)); err != nil {
+		return err
+	}
+	return enc.float64(imag(v))
+}
+
+// int64 writes v as a zigzag varint, so small negative values stay compact
 func (enc *encode) int64(v int64) error {
-	return enc.uint64(uint64(v))
+	len := binary.PutVarint(enc.scratch[:binary.MaxVarintLen64], v)
+	if _, err := enc.buf.Write(enc.scratch[0:len]); err != nil {
+		return err
+	}
+	return nil
 }
 
 func (enc *encode) uvarint(v uint64) error {
@@ -128,17 +258,62 @@ func (enc *encode) uvarint(v uint64) error {
 	return nil
 }
 
+// time writes the wall clock as Unix seconds and nanoseconds followed by the zone offset and name,
+// the monotonic clock reading is dropped
+func (enc *encode) time(v time.Time) error {
+	if err := enc.int64(v.Unix()); err != nil {
+		return err
+	}
+	if err := enc.uvarint(uint64(v.Nanosecond())); err != nil {
+		return err
+	}
+	name, offset := v.Zone()
+	if err := enc.int64(int64(offset)); err != nil {
+		return err
+	}
+	return enc.string(name)
+}
+
+// bigInt writes the sign followed by the absolute value as big-endian bytes
+func (enc *encode) bigInt(v *big.Int) error {
+	var sign uint8
+	if v.Sign() < 0 {
+		sign = 1
+	}
+	if err := enc.uint8(sign); err != nil {
+		return err
+	}
+	return enc.bytes(v.Bytes())
+}
+
 func (enc *encode) string(v string) error {
-	str := str2bytes(v)
-	if err := enc.uvarint(uint64(len(str))); err != nil {
+	return enc.bytes(str2bytes(v))
+}
+
+func (enc *encode) bytes(v []byte) error {
+	if err := enc.uvarint(uint64(len(v))); err != nil {
 		return err
 	}
-	if _, err := enc.buf.Write(str); err != nil {
+	if _, err := enc.buf.Write(v); err != nil {
 		return err
 	}
 	return nil
 }
 
+// writeColumns writes the number and the names of the columns and reserves
+// the table for their sizes, which the caller fills in with putColumnSize
+func (enc *encode) writeColumns(fields []field) ([]byte, error) {
+	if err := enc.uvarint(uint64(len(fields))); err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if err := enc.string(field.name); err != nil {
+			return nil, err
+		}
+	}
+	return enc.buf.alloc(4 * len(fields)), nil
+}
+
 func str2bytes(str string) []byte {
 	header := (*reflect.SliceHeader)(unsafe.Pointer(&str))
 	header.Len = len(str)
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -166,3 +167,44 @@ func Benchmark_EncodeString(b *testing.B) {
 		enc.buf.free()
 	}
 }
+
+func Benchmark_EncodeBytes(b *testing.B) {
+	var (
+		enc = encode{
+			buf: newBuffer(4096),
+		}
+		bytes = make([]byte, 4000)
+	)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := enc.bytes(bytes); err != nil {
+			b.Fatal(err)
+		}
+		enc.buf.free()
+	}
+}
+
+func Benchmark_EncodePtrSlice(b *testing.B) {
+	var (
+		enc = encode{
+			buf: newBuffer(4096),
+		}
+		values = make([]*int, 1000)
+	)
+	for i := range values {
+		values[i] = &i
+	}
+	var (
+		value  = reflect.ValueOf(values)
+		encode = getEncodeFunc(value.Type())
+	)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := encode(&enc, value); err != nil {
+			b.Fatal(err)
+		}
+		enc.buf.free()
+	}
+}
@@ -1,50 +1,159 @@
 package encoding
 
-import "reflect"
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
 
 type encodeFunc func(enc *encode, v reflect.Value) error
 
-var encodeFuncMap map[reflect.Kind]encodeFunc
+var (
+	encodeFuncMap map[reflect.Kind]encodeFunc
+	// encodeTypeMap holds the codecs of the types that are not encoded by their kind
+	encodeTypeMap map[reflect.Type]encodeFunc
+)
 
 func init() {
+	typeEncodeFuncs.funcs = make(map[reflect.Type]encodeFunc)
+	encodeTypeMap = map[reflect.Type]encodeFunc{
+		timeType:        encodeTime,
+		durationType:    encodeInt,
+		bigIntType:      encodeBigInt,
+		bigFloatType:    encodeBigFloat,
+		bigRatType:      encodeBigRat,
+		netipAddrType:   encodeNetipAddr,
+		netipPrefixType: encodeNetipPrefix,
+		netIPType:       encodeNetIP,
+	}
 	encodeFuncMap = map[reflect.Kind]encodeFunc{
-		reflect.Struct: encodeStruct,
-		reflect.String: encodeString,
-		reflect.Uint32: encodeUInt32,
-		reflect.Uint64: encodeUInt64,
+		reflect.Struct:     encodeStruct,
+		reflect.String:     encodeString,
+		reflect.Bool:       encodeBool,
+		reflect.Int:        encodeInt,
+		reflect.Int8:       encodeInt,
+		reflect.Int16:      encodeInt,
+		reflect.Int32:      encodeInt,
+		reflect.Int64:      encodeInt,
+		reflect.Uint:       encodeUVarint,
+		reflect.Uint8:      encodeUInt8,
+		reflect.Uint16:     encodeUInt16,
+		reflect.Uint32:     encodeUInt32,
+		reflect.Uint64:     encodeUInt64,
+		reflect.Uintptr:    encodeUVarint,
+		reflect.Float32:    encodeFloat32,
+		reflect.Float64:    encodeFloat64,
+		reflect.Complex64:  encodeComplex64,
+		reflect.Complex128: encodeComplex128,
+		reflect.Interface:  encodeInterface,
 	}
 }
 
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
 func encodeStruct(enc *encode, v reflect.Value) error {
-	fields := fields(v.Type())
-	if err := enc.uvarint(uint64(len(fields))); err != nil {
+	fields := presentFields(fields(v.Type()), v)
+	offsets, err := enc.writeColumns(fields)
+	if err != nil {
 		return err
 	}
-	for _, field := range fields {
-		if err := enc.string(field.name); err != nil {
+	for i, field := range fields {
+		startOffset := enc.buf.len()
+		value, _ := field.value(v)
+		if err := field.encode(enc, value); err != nil {
 			return err
 		}
+		putColumnSize(offsets, i, enc.buf.len()-startOffset)
+	}
+	return nil
+}
+
+// encodeStructSlice writes a slice of structs column-wise: each column holds
+// the values of one field for all the elements in a row. A column is written
+// if at least one element has a value for it, the others get the zero value
+func encodeStructSlice(enc *encode, v reflect.Value) error {
+	ln := v.Len()
+	if err := enc.uvarint(uint64(ln)); err != nil {
+		return err
+	}
+	if ln == 0 {
+		return nil
+	}
+	fields := presentFields(fields(v.Type().Elem()), v)
+	if pointers := mixedPointers(embeddedPointers(v.Type().Elem()), v); len(pointers) != 0 {
+		fields = append(fields[:len(fields):len(fields)], pointers...)
 	}
-	offsets := enc.buf.alloc(4 * len(fields))
+	offsets, err := enc.writeColumns(fields)
+	if err != nil {
+		return err
+	}
+	start := enc.buf.len()
 	for i, field := range fields {
 		startOffset := enc.buf.len()
-		if err := field.encode(enc, v.Field(i)); err != nil {
-			return err
-		}
-		var (
-			idx  = 4 * i
-			bLen = int32(enc.buf.len() - startOffset)
-		)
-		{
-			offsets[idx+0] = byte(bLen)
-			offsets[idx+1] = byte(bLen >> 8)
-			offsets[idx+2] = byte(bLen >> 16)
-			offsets[idx+3] = byte(bLen >> 24)
+		for j := 0; j < ln; j++ {
+			value, ok := field.value(v.Index(j))
+			if !ok {
+				value = reflect.Zero(field.typ)
+			}
+			if err := field.encode(enc, value); err != nil {
+				return err
+			}
 		}
+		putColumnSize(offsets, i, enc.buf.len()-startOffset)
+	}
+	// The columns take no space when there are none or all of them are empty: a byte per element
+	// is written instead, so the decoder can check the number of elements against the frame
+	if enc.buf.len() == start && v.Type().Elem().Size() != 0 {
+		clear(enc.buf.alloc(ln))
 	}
 	return nil
 }
 
+// putColumnSize stores the size of the i-th column in the offsets table returned by encode.writeColumns
+func putColumnSize(offsets []byte, i, size int) {
+	var (
+		idx  = 4 * i
+		bLen = int32(size)
+	)
+	{
+		offsets[idx+0] = byte(bLen)
+		offsets[idx+1] = byte(bLen >> 8)
+		offsets[idx+2] = byte(bLen >> 16)
+		offsets[idx+3] = byte(bLen >> 24)
+	}
+}
+
+func encodeTime(enc *encode, v reflect.Value) error {
+	return enc.time(v.Interface().(time.Time))
+}
+
+func encodeBool(enc *encode, v reflect.Value) error {
+	return enc.bool(v.Bool())
+}
+
+// encodeInt is also used for platform-sized ints, varints read the same on 32-bit and 64-bit hosts
+func encodeInt(enc *encode, v reflect.Value) error {
+	return enc.int64(v.Int())
+}
+
+func encodeUInt8(enc *encode, v reflect.Value) error {
+	return enc.uint8(uint8(v.Uint()))
+}
+
+func encodeUInt16(enc *encode, v reflect.Value) error {
+	return enc.uint16(uint16(v.Uint()))
+}
+
 func encodeUInt32(enc *encode, v reflect.Value) error {
 	return enc.uint32(uint32(v.Uint()))
 }
@@ -53,15 +162,449 @@ func encodeUInt64(enc *encode, v reflect.Value) error {
 	return enc.uint64(v.Uint())
 }
 
+// encodeUVarint is used for platform-sized uint and uintptr
+func encodeUVarint(enc *encode, v reflect.Value) error {
+	return enc.uvarint(v.Uint())
+}
+
+// encodeFloat32 reads the value through its address: v.Float() widens it to float64,
+// which quiets signaling NaNs and would change their bits
+func encodeFloat32(enc *encode, v reflect.Value) error {
+	return enc.float32(*(*float32)(unsafe.Pointer(addressable(v).UnsafeAddr())))
+}
+
+func encodeFloat64(enc *encode, v reflect.Value) error {
+	return enc.float64(v.Float())
+}
+
+// encodeComplex64 reads the value through its address for the same reason as encodeFloat32
+func encodeComplex64(enc *encode, v reflect.Value) error {
+	return enc.complex64(*(*complex64)(unsafe.Pointer(addressable(v).UnsafeAddr())))
+}
+
+func encodeComplex128(enc *encode, v reflect.Value) error {
+	return enc.complex128(v.Complex())
+}
+
 func encodeString(enc *encode, v reflect.Value) error {
 	return enc.string(v.String())
 }
 
-func getEncodeFunc(k reflect.Kind) encodeFunc {
-	if fn, ok := encodeFuncMap[k]; ok {
-		return fn
+// newSliceEncodeFunc returns the codec of the slices of type t, which writes the length followed
+// by the elements. Elements of a fixed width are written in bulk and structs column-wise
+func newSliceEncodeFunc(t reflect.Type) encodeFunc {
+	elem := t.Elem()
+	switch {
+	case elem.Kind() == reflect.Uint8 && typeEncodeFunc(elem) == nil:
+		return encodeBytes
+	case columnar(elem):
+		return func(enc *encode, v reflect.Value) error {
+			if err := enc.enter(v); err != nil {
+				return err
+			}
+			err := encodeStructSlice(enc, v)
+			enc.leave(v)
+			return err
+		}
 	}
+	if size := packedSize(elem); size != 0 {
+		kind := elem.Kind()
+		return func(enc *encode, v reflect.Value) error {
+			ln := v.Len()
+			if err := enc.uvarint(uint64(ln)); err != nil || ln == 0 {
+				return err
+			}
+			encodeFixed(enc.buf.alloc(size*ln), kind, v.UnsafePointer(), ln)
+			return nil
+		}
+	}
+	fn := lazyEncodeFunc(elem)
 	return func(enc *encode, v reflect.Value) error {
+		if err := enc.enter(v); err != nil {
+			return err
+		}
+		defer enc.leave(v)
+		ln := v.Len()
+		if err := enc.uvarint(uint64(ln)); err != nil {
+			return err
+		}
+		for i := 0; i < ln; i++ {
+			if err := fn(enc, v.Index(i)); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 }
+
+func encodeBytes(enc *encode, v reflect.Value) error {
+	return enc.bytes(v.Bytes())
+}
+
+// newArrayEncodeFunc returns the codec of the arrays of type t, which writes the elements only,
+// the length is known from the type
+func newArrayEncodeFunc(t reflect.Type) encodeFunc {
+	var (
+		ln   = t.Len()
+		elem = t.Elem()
+	)
+	if ln == 0 {
+		return encodeNothing
+	}
+	if size := packedSize(elem); size != 0 {
+		kind := elem.Kind()
+		return func(enc *encode, v reflect.Value) error {
+			encodeFixed(enc.buf.alloc(size*ln), kind, unsafe.Pointer(addressable(v).UnsafeAddr()), ln)
+			return nil
+		}
+	}
+	fn := lazyEncodeFunc(elem)
+	return func(enc *encode, v reflect.Value) error {
+		for i := 0; i < ln; i++ {
+			if err := fn(enc, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// packedSize returns the fixed width of the elements of type t which are written in bulk, or 0.
+// The types with a codec of their own are written one by one
+func packedSize(t reflect.Type) int {
+	size := fixedSize(t.Kind())
+	if size == 0 || typeEncodeFunc(t) != nil {
+		return 0
+	}
+	return size
+}
+
+// fixedSize returns the encoded size of the kinds that are stored with a fixed width, or 0
+func fixedSize(k reflect.Kind) int {
+	switch k {
+	case reflect.Bool, reflect.Uint8:
+		return 1
+	case reflect.Uint16:
+		return 2
+	case reflect.Uint32, reflect.Float32:
+		return 4
+	case reflect.Uint64, reflect.Float64, reflect.Complex64:
+		return 8
+	case reflect.Complex128:
+		return 16
+	}
+	return 0
+}
+
+// encodeFixed packs ln elements of a fixed width kind stored at ptr into b,
+// complex numbers are packed as pairs of floats
+func encodeFixed(b []byte, k reflect.Kind, ptr unsafe.Pointer, ln int) {
+	switch k {
+	case reflect.Bool, reflect.Uint8:
+		copy(b, unsafe.Slice((*byte)(ptr), ln))
+	case reflect.Uint16:
+		for i, v := range unsafe.Slice((*uint16)(ptr), ln) {
+			binary.LittleEndian.PutUint16(b[2*i:], v)
+		}
+	case reflect.Uint32, reflect.Float32, reflect.Complex64:
+		for i, v := range unsafe.Slice((*uint32)(ptr), len(b)/4) {
+			binary.LittleEndian.PutUint32(b[4*i:], v)
+		}
+	case reflect.Uint64, reflect.Float64, reflect.Complex128:
+		for i, v := range unsafe.Slice((*uint64)(ptr), len(b)/8) {
+			binary.LittleEndian.PutUint64(b[8*i:], v)
+		}
+	}
+}
+
+const (
+	ptrNil = iota
+	ptrValue
+	// ptrShared is written instead of ptrValue by Encoder.SharePointers, it is followed by
+	// the 4-byte size of the pointee. The later ptrRef markers refer to the pointee by its offset
+	// in the frame, so it can be decoded on demand if its column is decoded later or not at all
+	ptrShared
+	ptrRef
+)
+
+// cycleDepth is the nesting after which the encoder starts looking for cycles
+const cycleDepth = 1000
+
+// encodePtrWith writes a presence marker followed by the pointee, if any
+func encodePtrWith(enc *encode, v reflect.Value, encode encodeFunc) error {
+	if v.IsNil() {
+		return enc.uint8(ptrNil)
+	}
+	if enc.sharing {
+		if offset, found := enc.pointers[newPointerKey(v)]; found {
+			if err := enc.uint8(ptrRef); err != nil {
+				return err
+			}
+			return enc.uvarint(uint64(offset))
+		}
+		if err := enc.uint8(ptrShared); err != nil {
+			return err
+		}
+		var (
+			size  = enc.buf.alloc(4)
+			start = enc.buf.len()
+		)
+		enc.share(v, start)
+		if err := encode(enc, v.Elem()); err != nil {
+			return err
+		}
+		putColumnSize(size, 0, enc.buf.len()-start)
+		return nil
+	}
+	if err := enc.enter(v); err != nil {
+		return err
+	}
+	if err := enc.uint8(ptrValue); err != nil {
+		return err
+	}
+	err := encode(enc, v.Elem())
+	enc.leave(v)
+	return err
+}
+
+// ptrEncodeFunc returns the codec of pointers to the values written by fn
+func ptrEncodeFunc(fn encodeFunc) encodeFunc {
+	return func(enc *encode, v reflect.Value) error {
+		return encodePtrWith(enc, v, fn)
+	}
+}
+
+// encodeInterface writes the registered name of the concrete type followed by the value,
+// an empty name stands for nil
+func encodeInterface(enc *encode, v reflect.Value) error {
+	if variants, ok := unionVariants(v.Type()); ok {
+		return encodeUnion(enc, v, variants)
+	}
+	if v.IsNil() {
+		return enc.string("")
+	}
+	elem := v.Elem()
+	name, err := registeredName(elem.Type())
+	if err != nil {
+		return err
+	}
+	if err := enc.string(name); err != nil {
+		return err
+	}
+	return getEncodeFunc(elem.Type())(enc, elem)
+}
+
+// encodeUnion writes the position of the type of the value among variants, 0 stands for nil
+func encodeUnion(enc *encode, v reflect.Value, variants []reflect.Type) error {
+	if v.IsNil() {
+		return enc.uvarint(0)
+	}
+	elem := v.Elem()
+	for i, t := range variants {
+		if t == elem.Type() {
+			if err := enc.uvarint(uint64(i + 1)); err != nil {
+				return err
+			}
+			return getEncodeFunc(t)(enc, elem)
+		}
+	}
+	return fmt.Errorf("encoding: type %s is not a variant of %s", elem.Type(), v.Type())
+}
+
+// newMapEncodeFunc returns the codec of the maps of type t
+func newMapEncodeFunc(t reflect.Type) encodeFunc {
+	m := mapEncoder{
+		textKey:     isTextKey(t.Key()),
+		encodeKey:   lazyEncodeFunc(t.Key()),
+		encodeValue: lazyEncodeFunc(t.Elem()),
+	}
+	return m.encode
+}
+
+// mapEncoder writes the number of entries followed by the keys and values, ordered by key
+type mapEncoder struct {
+	textKey     bool
+	encodeKey   encodeFunc
+	encodeValue encodeFunc
+}
+
+func (m *mapEncoder) encode(enc *encode, v reflect.Value) error {
+	if err := enc.enter(v); err != nil {
+		return err
+	}
+	defer enc.leave(v)
+	ln := v.Len()
+	if err := enc.uvarint(uint64(ln)); err != nil {
+		return err
+	}
+	if ln == 0 {
+		return nil
+	}
+	var (
+		keys   = make([]reflect.Value, 0, ln)
+		values = make([]reflect.Value, 0, ln)
+		iter   = v.MapRange()
+	)
+	for iter.Next() {
+		keys, values = append(keys, iter.Key()), append(values, iter.Value())
+	}
+	if m.textKey {
+		texts := make([]string, ln)
+		for i, key := range keys {
+			text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return err
+			}
+			texts[i] = string(text)
+		}
+		sort.Sort(textEntries{texts: texts, values: values})
+		for i, text := range texts {
+			if err := enc.string(text); err != nil {
+				return err
+			}
+			if err := m.encodeValue(enc, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sort.Sort(entries{keys: keys, values: values})
+	for i, key := range keys {
+		if err := m.encodeKey(enc, key); err != nil {
+			return err
+		}
+		if err := m.encodeValue(enc, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTextKey reports whether map keys of type t are written as text, like encoding/json does
+func isTextKey(t reflect.Type) bool {
+	return t.Kind() != reflect.String && t.Implements(textMarshalerType) && reflect.PointerTo(t).Implements(textUnmarshalerType) && !promotedMarshaler(t)
+}
+
+type entries struct{ keys, values []reflect.Value }
+
+func (e entries) Len() int           { return len(e.keys) }
+func (e entries) Less(i, j int) bool { return compare(e.keys[i], e.keys[j]) < 0 }
+func (e entries) Swap(i, j int) {
+	e.keys[i], e.keys[j] = e.keys[j], e.keys[i]
+	e.values[i], e.values[j] = e.values[j], e.values[i]
+}
+
+type textEntries struct {
+	texts  []string
+	values []reflect.Value
+}
+
+func (e textEntries) Len() int           { return len(e.texts) }
+func (e textEntries) Less(i, j int) bool { return e.texts[i] < e.texts[j] }
+func (e textEntries) Swap(i, j int) {
+	e.texts[i], e.texts[j] = e.texts[j], e.texts[i]
+	e.values[i], e.values[j] = e.values[j], e.values[i]
+}
+
+// columnar reports whether the slices of t are written column-wise by encodeStructSlice
+func columnar(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && typeEncodeFunc(t) == nil
+}
+
+// typeEncodeFuncs caches typeEncodeFunc, which is looked up for every interface value
+var typeEncodeFuncs struct {
+	mutex sync.RWMutex
+	funcs map[reflect.Type]encodeFunc
+}
+
+// typeEncodeFunc returns the codec of the types that are not encoded by their kind, or nil
+func typeEncodeFunc(t reflect.Type) encodeFunc {
+	typeEncodeFuncs.mutex.RLock()
+	fn, ok := typeEncodeFuncs.funcs[t]
+	typeEncodeFuncs.mutex.RUnlock()
+	if !ok {
+		fn = newTypeEncodeFunc(t)
+		typeEncodeFuncs.mutex.Lock()
+		typeEncodeFuncs.funcs[t] = fn
+		typeEncodeFuncs.mutex.Unlock()
+	}
+	return fn
+}
+
+func newTypeEncodeFunc(t reflect.Type) encodeFunc {
+	if fn, ok := encodeTypeMap[t]; ok {
+		return fn
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		// The codec of the pointee is resolved on first use, a pointer type may point to itself
+		return ptrEncodeFunc(lazyEncodeFunc(t.Elem()))
+	case reflect.Interface:
+		// Interfaces are unwrapped by their own codec first
+		return nil
+	}
+	if promotedMarshaler(t) {
+		return nil
+	}
+	if err := marshalerError(t); err != nil {
+		fn, _ := errorCodec(err)
+		return fn
+	}
+	switch {
+	case t.Implements(marshalerType):
+		return encodeMarshaler
+	case reflect.PointerTo(t).Implements(marshalerType):
+		return encodeAddrMarshaler
+	case nullable(t):
+		return nullableEncodeFunc(t)
+	}
+	if fn := blobEncodeFunc(t); fn != nil {
+		return fn
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		return newSliceEncodeFunc(t)
+	case reflect.Array:
+		return newArrayEncodeFunc(t)
+	case reflect.Map:
+		return newMapEncodeFunc(t)
+	}
+	return nil
+}
+
+func getEncodeFunc(t reflect.Type) encodeFunc {
+	if fn := typeEncodeFunc(t); fn != nil {
+		return fn
+	}
+	if fn, ok := encodeFuncMap[t.Kind()]; ok {
+		return fn
+	}
+	return encodeNothing
+}
+
+// lazyEncodeFunc returns a codec which looks up the codec of t when it is first called
+func lazyEncodeFunc(t reflect.Type) encodeFunc {
+	var (
+		once sync.Once
+		fn   encodeFunc
+	)
+	return func(enc *encode, v reflect.Value) error {
+		once.Do(func() { fn = getEncodeFunc(t) })
+		return fn(enc, v)
+	}
+}
+
+// encodeNothing is the codec of the kinds which are not written, like channels and functions
+func encodeNothing(enc *encode, v reflect.Value) error {
+	return nil
+}
+
+// addressable returns v or, if v cannot be addressed, an addressable copy of it
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
@@ -2,9 +2,25 @@ package encoding
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
+func roundTrip(t *testing.T, in, out interface{}) bool {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(in); !assert.NoError(t, err) {
+		return false
+	}
+	return assert.NoError(t, NewDecoder(&buf).Decode(out))
+}
+
 func Test_Encode(t *testing.T) {
 	var buff bytes.Buffer
 	type (
@@ -52,6 +68,950 @@ func Test_Encode(t *testing.T) {
 	*/
 }
 
+func Test_TopLevel(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		enc    = NewEncoder(&buf)
+		str    = "abc"
+		ptr    = &str
+		values = []interface{}{
+			42,
+			uint8(7),
+			"abc",
+			3.5,
+			[]string{"a", "b"},
+			map[string]uint32{"a": 1},
+			[2]bool{true, false},
+			&ptr,
+			time.Duration(5),
+		}
+	)
+	for _, v := range values {
+		assert.NoError(t, enc.Encode(v))
+	}
+	var (
+		dec      = NewDecoder(&buf)
+		i        int
+		u8       uint8
+		s        string
+		f        float64
+		strings  []string
+		m        map[string]uint32
+		array    [2]bool
+		ptrPtr   **string
+		duration time.Duration
+	)
+	for _, out := range []interface{}{&i, &u8, &s, &f, &strings, &m, &array, &ptrPtr, &duration} {
+		assert.NoError(t, dec.Decode(out))
+	}
+	assert.Equal(t, 42, i)
+	assert.Equal(t, uint8(7), u8)
+	assert.Equal(t, "abc", s)
+	assert.Equal(t, 3.5, f)
+	assert.Equal(t, []string{"a", "b"}, strings)
+	assert.Equal(t, map[string]uint32{"a": 1}, m)
+	assert.Equal(t, [2]bool{true, false}, array)
+	if assert.NotNil(t, ptrPtr) && assert.NotNil(t, *ptrPtr) {
+		assert.Equal(t, "abc", **ptrPtr)
+	}
+	assert.Equal(t, time.Duration(5), duration)
+}
+
+func Test_TopLevelErrors(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		enc = NewEncoder(&buf)
+		ptr *string
+		v   int
+	)
+	assert.Error(t, enc.Encode(nil))
+	assert.Error(t, enc.Encode(ptr))
+	assert.Error(t, enc.Encode(struct{ V interface{} }{V: struct{}{}}))
+	assert.Equal(t, 0, buf.Len())
+	if assert.NoError(t, enc.Encode(1)) {
+		assert.Error(t, NewDecoder(&buf).Decode(v))
+		assert.NoError(t, NewDecoder(&buf).Decode(&v))
+		assert.Equal(t, 1, v)
+	}
+}
+
+func Test_Int(t *testing.T) {
+	type T struct {
+		Int   int
+		Int8  int8
+		Int16 int16
+		Int32 int32
+		Int64 int64
+	}
+	for _, in := range []T{
+		{},
+		{Int: -1, Int8: -1, Int16: -1, Int32: -1, Int64: -1},
+		{Int: 42, Int8: math.MaxInt8, Int16: math.MaxInt16, Int32: math.MaxInt32, Int64: math.MaxInt64},
+		{Int: -42, Int8: math.MinInt8, Int16: math.MinInt16, Int32: math.MinInt32, Int64: math.MinInt64},
+	} {
+		var out T
+		if roundTrip(t, in, &out) {
+			assert.Equal(t, in, out)
+		}
+	}
+}
+
+func Test_IntZigzag(t *testing.T) {
+	for _, v := range []int64{0, -1, 1, -64, 63} {
+		enc := encode{
+			buf: newBuffer(10),
+		}
+		if assert.NoError(t, enc.int64(v)) {
+			assert.Equal(t, 1, enc.buf.len(), v)
+		}
+	}
+}
+
+func Test_IntOverflow(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		in  struct{ V int64 }
+		out struct{ V int8 }
+	)
+	in.V = math.MaxInt8 + 1
+	if assert.NoError(t, NewEncoder(&buf).Encode(in)) {
+		assert.Error(t, NewDecoder(&buf).Decode(&out))
+	}
+}
+
+func Test_Uint(t *testing.T) {
+	type T struct {
+		Bool    bool
+		Uint8   uint8
+		Uint16  uint16
+		Uint    uint
+		Uintptr uintptr
+	}
+	for _, in := range []T{
+		{},
+		{Bool: true, Uint8: 1, Uint16: 1, Uint: 1, Uintptr: 1},
+		{Bool: true, Uint8: math.MaxUint8, Uint16: math.MaxUint16, Uint: math.MaxUint32, Uintptr: math.MaxUint32},
+	} {
+		var out T
+		if roundTrip(t, in, &out) {
+			assert.Equal(t, in, out)
+		}
+	}
+}
+
+func Test_InvalidBool(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		in  struct{ V uint8 }
+		out struct{ V bool }
+	)
+	in.V = 2
+	if assert.NoError(t, NewEncoder(&buf).Encode(in)) {
+		assert.Error(t, NewDecoder(&buf).Decode(&out))
+	}
+}
+
+func Test_Ptr(t *testing.T) {
+	type (
+		Inner struct {
+			V *string
+		}
+		T struct {
+			String *string
+			Uint64 *uint64
+			Inner  *Inner
+			PtrPtr **int
+			Slice  []*uint32
+			Map    map[string]*Inner
+			Nil    *Inner
+		}
+	)
+	var (
+		str = "abc"
+		u64 = uint64(math.MaxUint64)
+		i   = -1
+		ptr = &i
+		u32 = uint32(42)
+		in  = T{
+			String: &str,
+			Uint64: &u64,
+			Inner:  &Inner{V: &str},
+			PtrPtr: &ptr,
+			Slice:  []*uint32{&u32, nil},
+			Map:    map[string]*Inner{"a": {}, "b": nil},
+		}
+		out = T{
+			Nil: &Inner{},
+		}
+	)
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+		assert.True(t, in.String != out.String)
+		assert.Nil(t, out.Nil)
+	}
+}
+
+type selfPtr *selfPtr
+
+func Test_PtrSelf(t *testing.T) {
+	var (
+		inner selfPtr
+		in    = struct{ P selfPtr }{P: &inner}
+		out   struct{ P selfPtr }
+	)
+	if roundTrip(t, in, &out) && assert.NotNil(t, out.P) {
+		assert.Nil(t, *out.P)
+	}
+}
+
+type (
+	selfSlice []selfSlice
+	selfMap   map[string]selfMap
+)
+
+func Test_SelfContainers(t *testing.T) {
+	var (
+		in = struct {
+			S selfSlice
+			M selfMap
+			O []Optional[selfSlice]
+		}{
+			S: selfSlice{nil, {nil, nil}},
+			M: selfMap{"a": {"b": nil}, "c": nil},
+			O: []Optional[selfSlice]{{Value: selfSlice{nil}, Valid: true}, {}},
+		}
+		out = in
+	)
+	out.S, out.M, out.O = nil, nil, nil
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+type graphNode struct {
+	Name     string
+	Parent   *graphNode
+	Children []*graphNode
+	Next     *graphNode
+}
+
+func Test_SharePointers(t *testing.T) {
+	var (
+		root   = &graphNode{Name: "root"}
+		left   = &graphNode{Name: "left", Parent: root}
+		right  = &graphNode{Name: "right", Parent: root}
+		shared = &graphNode{Name: "shared"}
+	)
+	root.Children = []*graphNode{left, right}
+	left.Next, right.Next = shared, shared
+	shared.Next = shared
+	var (
+		buf     bytes.Buffer
+		encoder = NewEncoder(&buf)
+		decoder = NewDecoder(&buf)
+	)
+	encoder.SharePointers()
+	for i := 0; i < 2; i++ {
+		if !assert.NoError(t, encoder.Encode(root)) {
+			return
+		}
+		var out graphNode
+		if assert.NoError(t, decoder.Decode(&out)) && assert.Len(t, out.Children, 2) {
+			left, right := out.Children[0], out.Children[1]
+			assert.Equal(t, "left", left.Name)
+			assert.Equal(t, "right", right.Name)
+			assert.True(t, left.Parent == &out)
+			assert.True(t, right.Parent == &out)
+			assert.True(t, left.Parent.Children[0] == left)
+			assert.True(t, left.Next == right.Next)
+			assert.True(t, left.Next.Next == left.Next)
+			assert.Equal(t, "shared", left.Next.Name)
+		}
+	}
+}
+
+func Test_SharePointersColumns(t *testing.T) {
+	type (
+		Node struct {
+			V int
+		}
+		In struct {
+			A, B *Node
+		}
+		Reordered struct {
+			B, A *Node
+		}
+		Partial struct {
+			B *Node
+		}
+	)
+	var (
+		node    = &Node{V: 42}
+		buf     bytes.Buffer
+		encoder = NewEncoder(&buf)
+	)
+	encoder.SharePointers()
+	for i := 0; i < 2; i++ {
+		if !assert.NoError(t, encoder.Encode(In{A: node, B: node})) {
+			return
+		}
+	}
+	var (
+		decoder   = NewDecoder(&buf)
+		reordered Reordered
+		partial   Partial
+	)
+	if assert.NoError(t, decoder.Decode(&reordered)) && assert.NotNil(t, reordered.A) {
+		assert.True(t, reordered.A == reordered.B)
+		assert.Equal(t, 42, reordered.A.V)
+	}
+	if assert.NoError(t, decoder.Decode(&partial)) && assert.NotNil(t, partial.B) {
+		assert.Equal(t, 42, partial.B.V)
+	}
+}
+
+func Test_PointerCycle(t *testing.T) {
+	node := &graphNode{Name: "a"}
+	node.Next = &graphNode{Name: "b", Next: node}
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(node)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cycle")
+	}
+	var (
+		list = &graphNode{Name: "head"}
+		last = list
+	)
+	for i := 0; i < 2*cycleDepth; i++ {
+		last.Next = &graphNode{Name: "tail"}
+		last = last.Next
+	}
+	var out graphNode
+	if roundTrip(t, list, &out) {
+		assert.Equal(t, *list, out)
+	}
+}
+
+func Test_MapSliceCycle(t *testing.T) {
+	Register("cycle.map", map[string]interface{}{})
+	Register("cycle.slice", []interface{}{})
+	var (
+		buf   bytes.Buffer
+		m     = map[string]interface{}{}
+		slice = []interface{}{nil}
+	)
+	m["self"] = m
+	slice[0] = slice
+	for _, v := range []interface{}{m, slice} {
+		err := NewEncoder(&buf).Encode(v)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "cycle")
+		}
+	}
+}
+
+func Test_InvalidPointerReference(t *testing.T) {
+	var buf bytes.Buffer
+	if !assert.NoError(t, NewEncoder(&buf).Encode([]*int{nil})) {
+		return
+	}
+	frame := buf.Bytes()
+	frame[len(frame)-1] = ptrRef
+	buf.WriteByte(1)
+	frame = buf.Bytes()
+	frame[0]++
+	var out []*int
+	assert.EqualError(t, NewDecoder(&buf).Decode(&out), "encoding: invalid pointer reference 1")
+}
+
+func Test_Time(t *testing.T) {
+	type T struct {
+		Time      time.Time
+		Times     []time.Time
+		Duration  time.Duration
+		Durations []time.Duration
+		Ptr       *time.Time
+	}
+	var (
+		now   = time.Now()
+		zoned = time.Date(2017, 3, 1, 12, 30, 15, 123456789, time.FixedZone("MSK", 3*60*60))
+	)
+	for _, in := range []T{
+		{},
+		{
+			Time:      now.UTC(),
+			Times:     []time.Time{now, zoned, {}},
+			Duration:  -time.Hour,
+			Durations: []time.Duration{time.Nanosecond, math.MaxInt64},
+			Ptr:       &zoned,
+		},
+		{Time: time.Date(1, 1, 1, 0, 0, 0, 1, time.UTC)},
+		{Time: time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.FixedZone("", -5*60*60))},
+	} {
+		var out T
+		if roundTrip(t, in, &out) {
+			assert.True(t, in.Time.Equal(out.Time))
+			assert.Equal(t, in.Time.Round(0), out.Time)
+			assert.Equal(t, in.Duration, out.Duration)
+			assert.Equal(t, in.Durations, out.Durations)
+			if assert.Len(t, out.Times, len(in.Times)) {
+				for i, tm := range in.Times {
+					assert.True(t, tm.Equal(out.Times[i]))
+					name, offset := tm.Zone()
+					outName, outOffset := out.Times[i].Zone()
+					assert.Equal(t, name, outName)
+					assert.Equal(t, offset, outOffset)
+				}
+			}
+			if in.Ptr != nil && assert.NotNil(t, out.Ptr) {
+				assert.Equal(t, in.Ptr.String(), out.Ptr.String())
+			}
+		}
+	}
+}
+
+func Test_FloatAllocs(t *testing.T) {
+	type T struct {
+		A, B, C, D float32
+		Vector     [4]float32
+		Complex    complex64
+	}
+	var (
+		in      = T{A: 1, Vector: [4]float32{2}, Complex: 3}
+		encoder = NewEncoder(io.Discard)
+		byPtr   = testing.AllocsPerRun(10, func() { encoder.Encode(&in) })
+		byValue = testing.AllocsPerRun(10, func() { encoder.Encode(in) })
+	)
+	// Passing the struct by value costs the interface conversion and a single copy
+	assert.True(t, byValue <= byPtr+2, "%v allocs by value, %v by pointer", byValue, byPtr)
+}
+
+func Test_Float(t *testing.T) {
+	type T struct {
+		Float32 float32
+		Float64 float64
+	}
+	var (
+		float32s = []uint32{
+			math.Float32bits(0),
+			math.Float32bits(float32(math.Copysign(0, -1))),
+			math.Float32bits(float32(math.Inf(1))),
+			math.Float32bits(float32(math.Inf(-1))),
+			math.Float32bits(math.MaxFloat32),
+			math.Float32bits(math.SmallestNonzeroFloat32),
+			0x7fc00001, // quiet NaN with payload
+			0x7f800001, // signaling NaN
+			0xffc0beef,
+		}
+		float64s = []uint64{
+			math.Float64bits(0),
+			math.Float64bits(math.Copysign(0, -1)),
+			math.Float64bits(math.Inf(1)),
+			math.Float64bits(math.Inf(-1)),
+			math.Float64bits(math.MaxFloat64),
+			math.Float64bits(math.SmallestNonzeroFloat64),
+			0x7ff8000000000001, // quiet NaN with payload
+			0x7ff0000000000001, // signaling NaN
+			0xfff80000deadbeef,
+		}
+	)
+	for i := range float32s {
+		in := T{
+			Float32: math.Float32frombits(float32s[i]),
+			Float64: math.Float64frombits(float64s[i]),
+		}
+		var out T
+		if roundTrip(t, in, &out) {
+			assert.Equal(t, float32s[i], math.Float32bits(out.Float32))
+			assert.Equal(t, float64s[i], math.Float64bits(out.Float64))
+		}
+		if roundTrip(t, &in, &out) {
+			assert.Equal(t, float32s[i], math.Float32bits(out.Float32))
+			assert.Equal(t, float64s[i], math.Float64bits(out.Float64))
+		}
+	}
+}
+
+func Test_UInt64(t *testing.T) {
+	type T struct {
+		V uint64
+	}
+	for _, v := range []uint64{0, 1, 1 << 32, 1 << 40, 1<<48 - 1, math.MaxUint64, 1476374400123456789} {
+		var out T
+		if roundTrip(t, T{V: v}, &out) {
+			assert.Equal(t, v, out.V)
+		}
+	}
+}
+
+func Test_LegacyFrame(t *testing.T) {
+	var (
+		out struct {
+			V uint64
+			S string
+		}
+		frame = []byte{
+			23, 0, 0, 0, // frame length
+			2,      // columns
+			1, 'V', // column names
+			1, 'S',
+			6, 0, 0, 0, // column sizes
+			4, 0, 0, 0,
+			0xef, 0xbe, 0xad, 0xde, 0x12, 0xab, // legacy 6-byte uint64
+			3, 'a', 'b', 'c',
+		}
+	)
+	if assert.NoError(t, NewDecoder(bytes.NewReader(frame)).Decode(&out)) {
+		assert.Equal(t, uint64(0xab120000deadbeef), out.V)
+		assert.Equal(t, "abc", out.S)
+	}
+}
+
+// Test_LegacyFrameSkipped decodes frames of the first encoder, which wrote empty
+// columns for the kinds it did not support and an empty struct for time.Time
+func Test_LegacyFrameSkipped(t *testing.T) {
+	var (
+		out struct {
+			Name  string
+			Count int
+			Big   uint64
+		}
+		frame = []byte{
+			0x26, 0x0, 0x0, 0x0, 0x3, 0x4, 0x4e, 0x61, 0x6d, 0x65, 0x5, 0x43, 0x6f, 0x75, 0x6e, 0x74,
+			0x3, 0x42, 0x69, 0x67, 0x4, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x0, 0x0, 0x0,
+			0x3, 0x61, 0x62, 0x63, 0xef, 0xbe, 0xad, 0xde, 0x0, 0x0,
+		}
+	)
+	if assert.NoError(t, NewDecoder(bytes.NewReader(frame)).Decode(&out)) {
+		assert.Equal(t, "abc", out.Name)
+		assert.Equal(t, 0, out.Count)
+		assert.Equal(t, uint64(0xdeadbeef), out.Big)
+	}
+	var (
+		at struct {
+			Name string
+			At   time.Time
+		}
+		timeFrame = []byte{
+			0x16, 0x0, 0x0, 0x0, 0x2, 0x4, 0x4e, 0x61, 0x6d, 0x65, 0x2, 0x41, 0x74, 0x4, 0x0, 0x0,
+			0x0, 0x1, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x0,
+		}
+	)
+	if assert.NoError(t, NewDecoder(bytes.NewReader(timeFrame)).Decode(&at)) {
+		assert.Equal(t, "abc", at.Name)
+		assert.True(t, at.At.IsZero())
+	}
+}
+
+func Test_UnsupportedVersion(t *testing.T) {
+	var out struct{ V uint64 }
+	frame := []byte{0, 0, 0, 0x80, formatVersion + 1}
+	assert.Error(t, NewDecoder(bytes.NewReader(frame)).Decode(&out))
+}
+
+func Test_TruncatedFrame(t *testing.T) {
+	var out struct{ V string }
+	frame := []byte{4, 0, 0, 0x80, formatVersion, 1, 1, 'V', 0}
+	assert.Error(t, NewDecoder(bytes.NewReader(frame)).Decode(&out))
+}
+
+func Test_Complex(t *testing.T) {
+	type T struct {
+		Complex64   complex64
+		Complex128  complex128
+		Complex64s  []complex64
+		Complex128s []complex128
+		Array       [2]complex128
+	}
+	var (
+		nan32 = math.Float32frombits(0x7f800001)
+		nan64 = math.Float64frombits(0x7ff0000000000001)
+		in    = T{
+			Complex64:   complex(nan32, -1.5),
+			Complex128:  complex(math.Inf(-1), nan64),
+			Complex64s:  []complex64{1 + 2i, complex(float32(math.Copysign(0, -1)), nan32)},
+			Complex128s: []complex128{-1i, complex(math.Pi, math.E)},
+			Array:       [2]complex128{1, 1i},
+		}
+		out T
+	)
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, math.Float32bits(real(in.Complex64)), math.Float32bits(real(out.Complex64)))
+		assert.Equal(t, imag(in.Complex64), imag(out.Complex64))
+		assert.Equal(t, math.Inf(-1), real(out.Complex128))
+		assert.Equal(t, math.Float64bits(nan64), math.Float64bits(imag(out.Complex128)))
+		if assert.Len(t, out.Complex64s, 2) {
+			assert.Equal(t, in.Complex64s[0], out.Complex64s[0])
+			assert.Equal(t, math.Float32bits(real(in.Complex64s[1])), math.Float32bits(real(out.Complex64s[1])))
+			assert.Equal(t, math.Float32bits(nan32), math.Float32bits(imag(out.Complex64s[1])))
+		}
+		assert.Equal(t, in.Complex128s, out.Complex128s)
+		assert.Equal(t, in.Array, out.Array)
+	}
+}
+
+func Test_ComplexPacked(t *testing.T) {
+	enc := encode{
+		buf: newBuffer(64),
+	}
+	if assert.NoError(t, getEncodeFunc(reflect.TypeOf([]complex64{}))(&enc, reflect.ValueOf([]complex64{1 + 2i, 3 + 4i}))) {
+		assert.Equal(t, []byte{
+			2,
+			0x00, 0x00, 0x80, 0x3f, 0x00, 0x00, 0x00, 0x40,
+			0x00, 0x00, 0x40, 0x40, 0x00, 0x00, 0x80, 0x40,
+		}, enc.buf.bytes())
+	}
+}
+
+func Test_Slice(t *testing.T) {
+	type T struct {
+		Bool    []bool
+		Uint8   []uint8
+		Uint16  []uint16
+		Uint32  []uint32
+		Uint64  []uint64
+		Int     []int
+		Float32 []float32
+		Float64 []float64
+		String  []string
+		Nested  [][]string
+		Nil     []uint32
+	}
+	in := T{
+		Bool:    []bool{true, false, true},
+		Uint8:   []uint8{1, 2, 3},
+		Uint16:  []uint16{1, math.MaxUint16},
+		Uint32:  []uint32{1, 2, math.MaxUint32},
+		Uint64:  []uint64{1 << 40, math.MaxUint64},
+		Int:     []int{-1, 0, 1, math.MinInt32},
+		Float32: []float32{-1.5, float32(math.Inf(1))},
+		Float64: []float64{math.Pi, math.Copysign(0, -1)},
+		String:  []string{"a", "", "abc"},
+		Nested:  [][]string{{"a"}, nil, {"b", "c"}},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_SliceReuse(t *testing.T) {
+	type T struct {
+		V []uint32
+		S []string
+	}
+	var (
+		values  = make([]uint32, 1, 10)
+		strings = make([]string, 1, 10)
+		out     = T{V: values, S: strings}
+	)
+	if roundTrip(t, T{V: []uint32{1, 2, 3}, S: []string{"a", "b"}}, &out) {
+		assert.Equal(t, []uint32{1, 2, 3}, out.V)
+		assert.Equal(t, []string{"a", "b"}, out.S)
+		assert.Equal(t, &values[0], &out.V[0])
+		assert.Equal(t, &strings[0], &out.S[0])
+	}
+}
+
+func Test_StructSlice(t *testing.T) {
+	type (
+		Row struct {
+			ID     uint32
+			Name   string
+			hidden int
+			Values []float64
+		}
+		T struct {
+			Rows  []Row
+			Empty []Row
+		}
+	)
+	in := T{
+		Rows: []Row{
+			{ID: 1, Name: "a", Values: []float64{1}},
+			{ID: 2, Name: "b"},
+			{ID: 3, Name: "c", Values: []float64{1, 2, 3}},
+		},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_StructSliceColumns(t *testing.T) {
+	type Row struct {
+		A uint32
+		B uint8
+	}
+	enc := encode{
+		buf: newBuffer(64),
+	}
+	if assert.NoError(t, getEncodeFunc(reflect.TypeOf([]Row{}))(&enc, reflect.ValueOf([]Row{{1, 2}, {3, 4}, {5, 6}}))) {
+		assert.Equal(t, []byte{
+			3,                 // rows
+			2, 1, 'A', 1, 'B', // columns
+			12, 0, 0, 0,
+			3, 0, 0, 0,
+			1, 0, 0, 0, 3, 0, 0, 0, 5, 0, 0, 0,
+			2, 4, 6,
+		}, enc.buf.bytes())
+	}
+}
+
+func Test_StructSliceMissingColumn(t *testing.T) {
+	type (
+		In struct {
+			A uint32
+		}
+		Out struct {
+			A uint32
+			B string
+		}
+	)
+	out := struct{ V []Out }{
+		V: make([]Out, 2, 4),
+	}
+	out.V[0].B = "stale"
+	if roundTrip(t, struct{ V []In }{V: []In{{1}, {2}}}, &out) {
+		assert.Equal(t, []Out{{A: 1}, {A: 2}}, out.V)
+	}
+}
+
+func Test_StructSliceNoColumns(t *testing.T) {
+	type (
+		Unexported struct {
+			x int
+		}
+		Empty struct {
+			Name string `encoder:"name,omitempty"`
+		}
+		Embedded struct {
+			*FieldsName
+		}
+	)
+	var unexported []Unexported
+	if roundTrip(t, make([]Unexported, 3), &unexported) {
+		assert.Len(t, unexported, 3)
+	}
+	var empty []Empty
+	if roundTrip(t, make([]Empty, 3), &empty) {
+		assert.Equal(t, make([]Empty, 3), empty)
+	}
+	var embedded []Embedded
+	if roundTrip(t, make([]Embedded, 3), &embedded) {
+		assert.Equal(t, make([]Embedded, 3), embedded)
+	}
+}
+
+func Test_StructSliceNoColumnsLength(t *testing.T) {
+	type T struct {
+		X int64 `encoder:",omitempty"`
+	}
+	for _, ln := range []uint64{4, 1 << 34, 1 << 50} {
+		var (
+			// No columns and a byte short of the padding
+			body  = append(binary.AppendUvarint([]byte{formatVersion}, ln), 0, 0, 0, 0)
+			frame = binary.LittleEndian.AppendUint32(nil, uint32(len(body)-1)|versionedFrame)
+			out   []T
+		)
+		frame = append(frame, body...)
+		assert.Equal(t, io.ErrUnexpectedEOF, NewDecoder(bytes.NewReader(frame)).Decode(&out), ln)
+	}
+}
+
+func Test_Bytes(t *testing.T) {
+	type (
+		Blob []byte
+		T    struct {
+			A []byte
+			B Blob
+			C []byte
+		}
+	)
+	var (
+		buf bytes.Buffer
+		enc = NewEncoder(&buf)
+		in  = []T{
+			{A: []byte("abc"), B: Blob{1, 2, 3}, C: []byte{0}},
+			{A: bytes.Repeat([]byte{0xff}, 1000), B: Blob{}},
+		}
+	)
+	for _, v := range in {
+		assert.NoError(t, enc.Encode(v))
+	}
+	for _, alias := range []bool{false, true} {
+		var (
+			frames = bytes.NewReader(buf.Bytes())
+			dec    = NewDecoder(frames)
+			out    = make([]T, len(in))
+		)
+		if alias {
+			dec.AliasBytes()
+		}
+		for i := range out {
+			assert.NoError(t, dec.Decode(&out[i]))
+		}
+		assert.Equal(t, []T{
+			{A: []byte("abc"), B: Blob{1, 2, 3}, C: []byte{0}},
+			{A: bytes.Repeat([]byte{0xff}, 1000)},
+		}, out)
+		assert.Equal(t, alias, cap(out[0].A) > len(out[0].A))
+	}
+}
+
+func Test_Array(t *testing.T) {
+	type (
+		ID    [16]byte
+		Point struct {
+			X, Y int
+		}
+		T struct {
+			ID      ID
+			Hash    [32]byte
+			Vector  [4]float32
+			Flags   [3]bool
+			Strings [2]string
+			Points  [2]Point
+			Matrix  [2][2]uint16
+			Empty   [0]uint64
+		}
+	)
+	in := T{
+		ID:      ID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Hash:    [32]byte{31: 0xff},
+		Vector:  [4]float32{1, -1, 0.5, float32(math.Inf(1))},
+		Flags:   [3]bool{true, false, true},
+		Strings: [2]string{"a", "bc"},
+		Points:  [2]Point{{1, 2}, {-3, -4}},
+		Matrix:  [2][2]uint16{{1, 2}, {3, math.MaxUint16}},
+	}
+	for _, v := range []interface{}{in, &in} {
+		out := T{Points: [2]Point{{5, 5}, {5, 5}}}
+		if roundTrip(t, v, &out) {
+			assert.Equal(t, in, out)
+		}
+	}
+}
+
+type arrayByte uint8
+
+func Test_ArrayNamedBytes(t *testing.T) {
+	type T struct {
+		A   [4]arrayByte
+		Map map[string][2]arrayByte
+	}
+	in := T{
+		A:   [4]arrayByte{1, 2, 3, 4},
+		Map: map[string][2]arrayByte{"a": {5, 6}},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	var array [4]arrayByte
+	if roundTrip(t, in.A, &array) {
+		assert.Equal(t, in.A, array)
+	}
+}
+
+func Test_ArrayNoLength(t *testing.T) {
+	enc := encode{
+		buf: newBuffer(16),
+	}
+	if assert.NoError(t, getEncodeFunc(reflect.TypeOf([4]byte{}))(&enc, reflect.ValueOf([4]byte{1, 2, 3, 4}))) {
+		assert.Equal(t, []byte{1, 2, 3, 4}, enc.buf.bytes())
+	}
+}
+
+func Test_SliceCorruptedLength(t *testing.T) {
+	var out struct{ V []uint64 }
+	frame := []byte{
+		13, 0, 0, 0x80, formatVersion,
+		1, 1, 'V',
+		6, 0, 0, 0,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0x0f, // length
+	}
+	assert.Error(t, NewDecoder(bytes.NewReader(frame)).Decode(&out))
+}
+
+type textKey struct {
+	A, B int
+}
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d:%d", k.A, k.B)), nil
+}
+
+func (k *textKey) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d:%d", &k.A, &k.B)
+	return err
+}
+
+func Test_Map(t *testing.T) {
+	type (
+		Value struct {
+			V string
+		}
+		T struct {
+			Strings map[string]int
+			Ints    map[int64][]string
+			Floats  map[float64]uint8
+			Structs map[uint32]Value
+			Text    map[textKey]uint64
+			Nested  map[string]map[string]bool
+			Nil     map[string]int
+		}
+	)
+	in := T{
+		Strings: map[string]int{"a": 1, "b": -2, "": 0},
+		Ints:    map[int64][]string{-1: {"a"}, 1: nil, 100: {"b", "c"}},
+		Floats:  map[float64]uint8{math.Inf(-1): 1, 0: 2, 1.5: 3},
+		Structs: map[uint32]Value{1: {"a"}, 2: {"b"}},
+		Text:    map[textKey]uint64{{1, 2}: 12, {3, 4}: 34},
+		Nested:  map[string]map[string]bool{"a": {"b": true}},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_MapDeterministic(t *testing.T) {
+	in := struct {
+		V map[string]uint32
+		T map[textKey]string
+	}{
+		V: make(map[string]uint32),
+		T: make(map[textKey]string),
+	}
+	for i := 0; i < 100; i++ {
+		in.V[fmt.Sprintf("key_%d", i)] = uint32(i)
+		in.T[textKey{i, -i}] = fmt.Sprint(i)
+	}
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if assert.NoError(t, NewEncoder(&buf).Encode(in)) {
+			if expected == nil {
+				expected = buf.Bytes()
+			}
+			assert.Equal(t, expected, buf.Bytes())
+		}
+	}
+}
+
+func Test_MapReuse(t *testing.T) {
+	var (
+		m   = map[string]int{"stale": 1}
+		out = struct{ V map[string]int }{V: m}
+	)
+	if roundTrip(t, struct{ V map[string]int }{V: map[string]int{"a": 1, "b": 2}}, &out) {
+		assert.Equal(t, map[string]int{"a": 1, "b": 2}, out.V)
+		assert.Equal(t, map[string]int{"a": 1, "b": 2}, m)
+	}
+}
+
 /*
 func Test_UInt8(t *testing.T) {
 	var prev uint8
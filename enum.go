@@ -0,0 +1,111 @@
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// enum maps the values of an 8 or 16 bit integer type to the names returned by its String method,
+// like Enum8 and Enum16 of ClickHouse. Values whose name is shared with another value or
+// follows the stringer convention for unknown values, like State(5), are left out
+type enum struct {
+	typ    reflect.Type
+	names  map[uint64]string
+	values map[string]uint64
+}
+
+// enumCodec returns the codecs of the fields tagged with the enum option, which are written as names
+func enumCodec(t reflect.Type) (encodeFunc, decodeFunc) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		encode, decode := enumCodec(t.Elem())
+		return ptrEncodeFunc(encode), ptrDecodeFunc(decode)
+	case reflect.Slice:
+		encode, decode := enumCodec(t.Elem())
+		return sliceEncodeFunc(encode), sliceDecodeFunc(decode)
+	case reflect.Int8, reflect.Int16, reflect.Uint8, reflect.Uint16:
+		if reflect.PointerTo(t).Implements(stringerType) {
+			e := newEnum(t)
+			return e.encode, e.decode
+		}
+	}
+	return errorCodec(fmt.Errorf("encoding: enum option requires an 8 or 16 bit integer type with a String method, got %s", t))
+}
+
+func newEnum(t reflect.Type) *enum {
+	var (
+		e = enum{
+			typ:    t,
+			names:  make(map[uint64]string),
+			values: make(map[string]uint64),
+		}
+		count     = uint64(1) << (8 * t.Size())
+		unknown   = t.Name() + "("
+		ambiguous = make(map[string]struct{})
+		v         = reflect.New(t)
+	)
+	for i := uint64(0); i < count; i++ {
+		e.set(v.Elem(), i)
+		name := v.Interface().(fmt.Stringer).String()
+		if name == "" || strings.HasPrefix(name, unknown) {
+			continue
+		}
+		if _, found := e.values[name]; found {
+			ambiguous[name] = struct{}{}
+			continue
+		}
+		e.values[name] = i
+	}
+	for name, i := range e.values {
+		if _, found := ambiguous[name]; found {
+			delete(e.values, name)
+			continue
+		}
+		e.names[i] = name
+	}
+	return &e
+}
+
+// key returns the bits of v, negative values are truncated to the size of the type
+func (e *enum) key(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16:
+		return uint64(v.Int()) & (1<<(8*e.typ.Size()) - 1)
+	}
+	return v.Uint()
+}
+
+func (e *enum) set(v reflect.Value, key uint64) {
+	switch v.Kind() {
+	case reflect.Int8:
+		v.SetInt(int64(int8(key)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(key)))
+	default:
+		v.SetUint(key)
+	}
+}
+
+func (e *enum) encode(enc *encode, v reflect.Value) error {
+	name, found := e.names[e.key(v)]
+	if !found {
+		return fmt.Errorf("encoding: %s value %d has no enum name", e.typ, v.Interface())
+	}
+	return enc.string(name)
+}
+
+func (e *enum) decode(d *decode, v reflect.Value) error {
+	name, err := d.string()
+	if err != nil {
+		return err
+	}
+	key, found := e.values[name]
+	if !found {
+		return fmt.Errorf("encoding: unknown %s enum name %q", e.typ, name)
+	}
+	e.set(v, key)
+	return nil
+}
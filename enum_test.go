@@ -0,0 +1,118 @@
+package encoding
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type enumState uint8
+
+const (
+	enumStateNew enumState = iota
+	enumStateActive
+	enumStateClosed
+)
+
+func (s enumState) String() string {
+	switch s {
+	case enumStateNew:
+		return "new"
+	case enumStateActive:
+		return "active"
+	case enumStateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("enumState(%d)", uint8(s))
+}
+
+type enumLevel int16
+
+func (l *enumLevel) String() string {
+	switch {
+	case *l < 0:
+		return "debug"
+	case *l == 0:
+		return "info"
+	case *l == 1000:
+		return "error"
+	}
+	return ""
+}
+
+func Test_Enum(t *testing.T) {
+	type T struct {
+		State  enumState   `encoder:"state,enum"`
+		Level  enumLevel   `encoder:"level,enum"`
+		Error  enumLevel   `encoder:"error,enum"`
+		States []enumState `encoder:"states,enum"`
+		Ptr    *enumState  `encoder:"ptr,enum"`
+		Raw    enumState
+	}
+	var (
+		closed = enumStateClosed
+		in     = T{
+			State:  enumStateActive,
+			Level:  enumLevel(0),
+			Error:  enumLevel(1000),
+			States: []enumState{enumStateClosed, enumStateNew},
+			Ptr:    &closed,
+			Raw:    enumStateActive,
+		}
+		out T
+	)
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_EnumRemapped(t *testing.T) {
+	type (
+		In struct {
+			State enumState `encoder:"state,enum"`
+		}
+		// renumbered is the same enum after a release which inserted a state before closed
+		Out struct {
+			State enumRenumbered `encoder:"state,enum"`
+		}
+	)
+	var buf bytes.Buffer
+	if assert.NoError(t, NewEncoder(&buf).Encode(In{State: enumStateClosed})) {
+		var out Out
+		if assert.NoError(t, NewDecoder(&buf).Decode(&out)) {
+			assert.Equal(t, enumRenumbered(3), out.State)
+		}
+	}
+}
+
+type enumRenumbered uint8
+
+func (s enumRenumbered) String() string {
+	return [...]string{"new", "active", "paused", "closed", ""}[min(int(s), 4)]
+}
+
+func Test_EnumErrors(t *testing.T) {
+	type (
+		T struct {
+			State enumState `encoder:"state,enum"`
+		}
+		Ambiguous struct {
+			Level enumLevel `encoder:"level,enum"`
+		}
+		Renumbered struct {
+			State enumRenumbered `encoder:"state,enum"`
+		}
+		Unsupported struct {
+			State int32 `encoder:"state,enum"`
+		}
+	)
+	var buf bytes.Buffer
+	assert.EqualError(t, NewEncoder(&buf).Encode(T{State: 7}), "encoding: encoding.enumState value 7 has no enum name")
+	assert.EqualError(t, NewEncoder(&buf).Encode(Unsupported{}), "encoding: enum option requires an 8 or 16 bit integer type with a String method, got int32")
+	assert.EqualError(t, NewEncoder(&buf).Encode(Ambiguous{Level: -1}), "encoding: encoding.enumLevel value -1 has no enum name")
+	if assert.NoError(t, NewEncoder(&buf).Encode(Renumbered{State: 2})) {
+		assert.EqualError(t, NewDecoder(&buf).Decode(&T{}), `encoding: unknown encoding.enumState enum name "paused"`)
+	}
+}
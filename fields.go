@@ -1,52 +1,461 @@
 package encoding
 
 import (
+	"encoding"
+	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 var fieldsCache struct {
 	mutex  sync.RWMutex
-	fields map[reflect.Type][]field
+	fields map[reflect.Type]structFields
 }
 
 func init() {
-	fieldsCache.fields = make(map[reflect.Type][]field, 0)
+	fieldsCache.fields = make(map[reflect.Type]structFields, 0)
+}
+
+// structFields are the fields of a struct type and the embedded pointers they are promoted through
+type structFields struct {
+	fields []field
+	// pointers are written as the presence columns of the struct slices whose elements
+	// have some of the embedded pointers nil, they are named "*" and the path to the pointer
+	pointers []field
 }
 
 type field struct {
-	name   string
-	encode encodeFunc
-	decode decodeFunc
+	name string
+	// index is the path to the field through the embedded structs, as in reflect.Value.FieldByIndex
+	index []int
+	typ   reflect.Type
+	// optional fields may have no value, their columns are not written then
+	optional bool
+	tagged   bool
+	// omitEmpty fields are not written when their value is zero, a required field fails
+	// the decoding when its column is missing, otherwise it is set to fallback if any
+	omitEmpty bool
+	required  bool
+	fallback  reflect.Value
+	encode    encodeFunc
+	decode    decodeFunc
+}
+
+// value returns the value of the field in the struct v, it reports false
+// if the field is promoted through an embedded pointer which is nil
+func (f *field) value(v reflect.Value) (reflect.Value, bool) {
+	if len(f.index) == 1 {
+		return v.Field(f.index[0]), true
+	}
+	for i, idx := range f.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+// present reports whether the field has a value to be written in the struct v
+func (f *field) present(v reflect.Value) bool {
+	value, ok := f.value(v)
+	return ok && !(f.omitEmpty && value.IsZero())
+}
+
+// missing handles the absence of the column of the field in the struct v
+func (f *field) missing(v reflect.Value) error {
+	switch {
+	case f.required:
+		return fmt.Errorf("encoding: required column %q is missing", f.name)
+	case !f.fallback.IsValid():
+		return nil
+	}
+	value, err := f.settable(v)
+	if err != nil {
+		return err
+	}
+	if f.typ.Kind() == reflect.Ptr {
+		// Every struct gets its own copy of the default
+		ptr := reflect.New(f.typ.Elem())
+		ptr.Elem().Set(f.fallback)
+		value.Set(ptr)
+		return nil
+	}
+	value.Set(f.fallback)
+	return nil
+}
+
+// settable returns the field of the struct v, allocating the embedded pointers on the way.
+// An embedded pointer to an unexported type can not be allocated, as in encoding/json
+func (f *field) settable(v reflect.Value) (reflect.Value, error) {
+	if len(f.index) == 1 {
+		return v.Field(f.index[0]), nil
+	}
+	for i, idx := range f.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("encoding: cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, nil
+}
+
+// presentFields drops the optional and omitempty fields which have no value in the struct v or,
+// if v is a slice of structs, in any of its elements
+func presentFields(fields []field, v reflect.Value) []field {
+	optional := false
+	for _, f := range fields {
+		optional = optional || f.optional || f.omitEmpty
+	}
+	if !optional {
+		return fields
+	}
+	present := make([]field, 0, len(fields))
+	for _, f := range fields {
+		if !f.optional && !f.omitEmpty {
+			present = append(present, f)
+			continue
+		}
+		if v.Kind() == reflect.Struct {
+			if f.present(v) {
+				present = append(present, f)
+			}
+			continue
+		}
+		for i := 0; i < v.Len(); i++ {
+			if f.present(v.Index(i)) {
+				present = append(present, f)
+				break
+			}
+		}
+	}
+	return present
+}
+
+// mixedPointers returns the embedded pointers which are nil in some elements of the slice v and set in others
+func mixedPointers(pointers []field, v reflect.Value) []field {
+	var mixed []field
+	for _, f := range pointers {
+		var set int
+		for i := 0; i < v.Len(); i++ {
+			if ptr, ok := f.value(v.Index(i)); ok && !ptr.IsNil() {
+				set++
+			}
+		}
+		if set != 0 && set != v.Len() {
+			mixed = append(mixed, f)
+		}
+	}
+	return mixed
 }
 
 func fields(v reflect.Type) []field {
+	return cachedFields(v).fields
+}
+
+func embeddedPointers(v reflect.Type) []field {
+	return cachedFields(v).pointers
+}
+
+func cachedFields(v reflect.Type) structFields {
 	fieldsCache.mutex.RLock()
-	_, ok := fieldsCache.fields[v]
+	fields, ok := fieldsCache.fields[v]
 	fieldsCache.mutex.RUnlock()
 	if !ok {
-		var (
-			numField = v.NumField()
-			fields   = make([]field, 0, numField)
-		)
-		for i := 0; i < numField; i++ {
-			f := v.Field(i)
-			name := f.Name
-			if n := f.Tag.Get("encoder"); len(n) != 0 {
-				name = n
-			}
-			if f.Anonymous || f.PkgPath != "" || name == "-" {
-				continue
-			}
-			fields = append(fields, field{
-				name:   name,
-				encode: getEncodeFunc(f.Type.Kind()),
-				decode: getDecodeFunc(f.Type.Kind()),
-			})
-		}
+		fields = typeFields(v)
 		fieldsCache.mutex.Lock()
 		fieldsCache.fields[v] = fields
 		fieldsCache.mutex.Unlock()
 	}
-	return fieldsCache.fields[v]
+	return fields
+}
+
+// typeFields returns the fields of the struct type t, including the ones promoted
+// from embedded structs. Name conflicts are resolved with the rules of encoding/json:
+// the least nested field wins, a tagged one wins among equally nested fields, otherwise all of them are dropped
+func typeFields(t reflect.Type) structFields {
+	type embedded struct {
+		typ      reflect.Type
+		index    []int
+		path     string
+		optional bool
+	}
+	var (
+		current []embedded
+		next    = []embedded{{typ: t}}
+		// Types already visited at an earlier level
+		visited  = map[reflect.Type]bool{}
+		fields   []field
+		pointers []field
+	)
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount := map[reflect.Type]int{}, map[reflect.Type]int{}
+		for _, e := range current {
+			count[e.typ]++
+		}
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+			for i := 0; i < e.typ.NumField(); i++ {
+				f := e.typ.Field(i)
+				if f.Anonymous {
+					t := f.Type
+					if t.Kind() == reflect.Ptr {
+						t = t.Elem()
+					}
+					// An unexported struct with a codec of its own is not promoted, it is an unexported field
+					if !f.IsExported() && (t.Kind() != reflect.Struct || typeEncodeFunc(t) != nil) {
+						continue
+					}
+				} else if !f.IsExported() {
+					continue
+				}
+				tag := f.Tag.Get("encoder")
+				if tag == "-" {
+					continue
+				}
+				var (
+					name, opts = parseTag(tag)
+					index      = append(append(make([]int, 0, len(e.index)+1), e.index...), i)
+					ft         = f.Type
+				)
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if name != "" || !f.Anonymous || ft.Kind() != reflect.Struct || typeEncodeFunc(ft) != nil || opts.Contains("nested") {
+					tagged := name != ""
+					if name == "" {
+						name = f.Name
+					}
+					field := field{
+						name:      name,
+						index:     index,
+						typ:       f.Type,
+						optional:  e.optional,
+						tagged:    tagged,
+						omitEmpty: opts.Contains("omitempty"),
+						required:  opts.Contains("required"),
+					}
+					field.encode, field.decode = fieldCodec(f.Type, opts)
+					if text, ok := opts.Lookup("default"); ok {
+						if fallback, err := parseDefault(f.Type, text); err != nil {
+							field.encode, field.decode = errorCodec(err)
+						} else {
+							field.fallback = fallback
+						}
+					}
+					fields = append(fields, field)
+					if count[e.typ] > 1 {
+						// The same type embedded twice at this level annihilates its fields,
+						// add a duplicate for the dominance rules to see it
+						fields = append(fields, field)
+					}
+					continue
+				}
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					path := f.Name
+					if e.path != "" {
+						path = e.path + "." + f.Name
+					}
+					next = append(next, embedded{
+						typ:      ft,
+						index:    index,
+						path:     path,
+						optional: e.optional || f.Type.Kind() == reflect.Ptr,
+					})
+					if f.Type.Kind() == reflect.Ptr {
+						pointers = append(pointers, field{
+							name:     "*" + path,
+							index:    index,
+							typ:      f.Type,
+							optional: true,
+							encode:   encodePresence,
+						})
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		x := fields
+		if x[i].name != x[j].name {
+			return x[i].name < x[j].name
+		}
+		if len(x[i].index) != len(x[j].index) {
+			return len(x[i].index) < len(x[j].index)
+		}
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+		return lessIndex(x[i].index, x[j].index)
+	})
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	fields = out
+	sort.Slice(fields, func(i, j int) bool {
+		return lessIndex(fields[i].index, fields[j].index)
+	})
+	return structFields{fields: fields, pointers: pointers}
+}
+
+// encodePresence writes whether the embedded pointer v is set
+func encodePresence(enc *encode, v reflect.Value) error {
+	return enc.bool(!v.IsNil())
+}
+
+// fieldCodec returns the codecs of a field, taking the options of its tag into account
+func fieldCodec(t reflect.Type, opts tagOptions) (encodeFunc, decodeFunc) {
+	if spec, ok := opts.Lookup("decimal"); ok {
+		return decimalCodec(t, spec)
+	}
+	if opts.Contains("uuid") {
+		return uuidCodec(t)
+	}
+	if opts.Contains("enum") {
+		return enumCodec(t)
+	}
+	if spec, ok := opts.Lookup("fixed"); ok {
+		return fixedCodec(t, spec)
+	}
+	return getEncodeFunc(t), getDecodeFunc(t)
+}
+
+// parseDefault parses the value of the default option for the type t, a pointer type gets
+// the value of its element. Types implementing encoding.TextUnmarshaler parse the text themselves
+func parseDefault(t reflect.Type, text string) (reflect.Value, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var (
+		v   = reflect.New(t).Elem()
+		err error
+	)
+	switch {
+	case t == durationType:
+		var d time.Duration
+		if d, err = time.ParseDuration(text); err == nil {
+			v.SetInt(int64(d))
+		}
+	case reflect.PointerTo(t).Implements(textUnmarshalerType):
+		err = v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+	default:
+		switch t.Kind() {
+		case reflect.Bool:
+			var b bool
+			if b, err = strconv.ParseBool(text); err == nil {
+				v.SetBool(b)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var i int64
+			if i, err = strconv.ParseInt(text, 10, t.Bits()); err == nil {
+				v.SetInt(i)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			var u uint64
+			if u, err = strconv.ParseUint(text, 10, t.Bits()); err == nil {
+				v.SetUint(u)
+			}
+		case reflect.Float32, reflect.Float64:
+			var f float64
+			if f, err = strconv.ParseFloat(text, t.Bits()); err == nil {
+				v.SetFloat(f)
+			}
+		case reflect.String:
+			v.SetString(text)
+		default:
+			return reflect.Value{}, fmt.Errorf("encoding: default option is not supported for %s", t)
+		}
+	}
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("encoding: invalid default %q for %s: %v", text, t, err)
+	}
+	return v, nil
+}
+
+// errorCodec returns the codecs of a field with invalid options, they fail with err
+func errorCodec(err error) (encodeFunc, decodeFunc) {
+	encode := func(*encode, reflect.Value) error { return err }
+	decode := func(*decode, reflect.Value) error { return err }
+	return encode, decode
+}
+
+// dominantField picks the field which hides the others with the same name,
+// the fields are sorted by depth and tagged ones go first
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+func lessIndex(a, b []int) bool {
+	for i, x := range a {
+		if i >= len(b) {
+			return false
+		}
+		if x != b[i] {
+			return x < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// tagOptions is the part of the encoder tag after the name
+type tagOptions string
+
+func parseTag(tag string) (string, tagOptions) {
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opts)
+}
+
+// Contains reports whether the comma-separated list of options contains the option
+func (o tagOptions) Contains(option string) bool {
+	for s := string(o); s != ""; {
+		var name string
+		name, s, _ = strings.Cut(s, ",")
+		if name == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the value of a key=value option
+func (o tagOptions) Lookup(key string) (string, bool) {
+	for s := string(o); s != ""; {
+		var option string
+		option, s, _ = strings.Cut(s, ",")
+		if k, v, ok := strings.Cut(option, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
 }
@@ -1,6 +1,7 @@
 package encoding
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
@@ -54,3 +55,249 @@ func Benchmark_Fields(b *testing.B) {
 		}
 	}
 }
+
+type (
+	fieldsMeta struct {
+		ID      uint64
+		Created string
+	}
+	// Embedded pointers must be of exported types to be allocated on decode
+	FieldsAudit struct {
+		Created string
+		User    string
+	}
+	FieldsName struct {
+		Name string
+	}
+	fieldsOther struct {
+		Name string
+	}
+	fieldsTagged struct {
+		Title string `encoder:"Name"`
+	}
+	fieldsDeep struct {
+		fieldsMeta
+	}
+	fieldsHidden struct {
+		Visible string
+		hidden  string
+	}
+)
+
+func Test_FieldsEmbedded(t *testing.T) {
+	type T struct {
+		fieldsMeta
+		*FieldsAudit
+		*fieldsOther
+		Value string
+	}
+	var names []string
+	for _, f := range fields(reflect.TypeOf(T{})) {
+		names = append(names, f.name)
+	}
+	// Created is defined twice at the same depth
+	assert.Equal(t, []string{"ID", "User", "Name", "Value"}, names)
+	var (
+		in  = T{fieldsOther: &fieldsOther{Name: "other"}, Value: "v"}
+		out = T{fieldsOther: &fieldsOther{}}
+	)
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	var buf bytes.Buffer
+	if assert.NoError(t, NewEncoder(&buf).Encode(in)) {
+		assert.EqualError(t, NewDecoder(&buf).Decode(&T{}), "encoding: cannot set embedded pointer to unexported struct encoding.fieldsOther")
+	}
+}
+
+func Test_FieldsShadowing(t *testing.T) {
+	type (
+		Shallow struct {
+			fieldsDeep
+			ID uint64
+		}
+		Conflict struct {
+			FieldsName
+			fieldsOther
+		}
+		Tagged struct {
+			FieldsName
+			fieldsTagged
+		}
+		Nested struct {
+			fieldsMeta `encoder:",nested"`
+			Named      FieldsName `encoder:"named"`
+		}
+		Unexported struct {
+			FieldsName
+			*fieldsOther
+		}
+	)
+	for _, test := range []struct {
+		value interface{}
+		names []string
+		index [][]int
+	}{
+		{Shallow{}, []string{"Created", "ID"}, [][]int{{0, 0, 1}, {1}}},
+		{Conflict{}, nil, nil},
+		{Tagged{}, []string{"Name"}, [][]int{{1, 0}}},
+		{Nested{}, []string{"fieldsMeta", "named"}, [][]int{{0}, {1}}},
+		{Unexported{}, nil, nil},
+	} {
+		var (
+			names []string
+			index [][]int
+		)
+		for _, f := range fields(reflect.TypeOf(test.value)) {
+			names, index = append(names, f.name), append(index, f.index)
+		}
+		assert.Equal(t, test.names, names)
+		assert.Equal(t, test.index, index)
+	}
+}
+
+func Test_EmbeddedRoundTrip(t *testing.T) {
+	type (
+		T struct {
+			fieldsMeta
+			*FieldsName
+			fieldsHidden
+			Value string
+		}
+		Nested struct {
+			fieldsMeta `encoder:",nested"`
+			Value      string
+		}
+	)
+	for _, in := range []T{
+		{fieldsMeta: fieldsMeta{ID: 1, Created: "now"}, FieldsName: &FieldsName{Name: "a"}, Value: "v"},
+		{fieldsMeta: fieldsMeta{ID: 2}, fieldsHidden: fieldsHidden{Visible: "x"}},
+	} {
+		var out T
+		if roundTrip(t, in, &out) {
+			assert.Equal(t, in, out)
+		}
+	}
+	var (
+		in = struct {
+			Rows []T
+		}{
+			Rows: []T{
+				{fieldsMeta: fieldsMeta{ID: 1}},
+				{fieldsMeta: fieldsMeta{ID: 2}, FieldsName: &FieldsName{Name: "b"}},
+				{fieldsMeta: fieldsMeta{ID: 3}},
+				{fieldsMeta: fieldsMeta{ID: 4}, FieldsName: &FieldsName{}},
+			},
+		}
+		out = in
+	)
+	out.Rows = nil
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	var nested Nested
+	if roundTrip(t, Nested{fieldsMeta: fieldsMeta{ID: 3}, Value: "v"}, &nested) {
+		assert.Equal(t, Nested{fieldsMeta: fieldsMeta{ID: 3}, Value: "v"}, nested)
+	}
+}
+
+func Test_OmitEmpty(t *testing.T) {
+	type T struct {
+		ID      int64
+		Name    string            `encoder:"name,omitempty"`
+		Tags    []string          `encoder:"tags,omitempty"`
+		Labels  map[string]string `encoder:"labels,omitempty"`
+		Created time.Time         `encoder:"created,omitempty"`
+	}
+	var (
+		empty bytes.Buffer
+		full  bytes.Buffer
+	)
+	if assert.NoError(t, NewEncoder(&empty).Encode(T{ID: 1})) && assert.NoError(t, NewEncoder(&full).Encode(T{ID: 1, Name: "a"})) {
+		// Only the ID column is written: count, name, size and the value
+		assert.Equal(t, 5+1+3+4+1, empty.Len())
+		assert.True(t, full.Len() > empty.Len())
+	}
+	in := []T{{ID: 1}, {ID: 2, Name: "b", Tags: []string{"x"}}}
+	var out []T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_Required(t *testing.T) {
+	type (
+		In struct {
+			ID int64
+		}
+		Out struct {
+			ID   int64
+			Name string `encoder:"name,required"`
+		}
+	)
+	var buf bytes.Buffer
+	if assert.NoError(t, NewEncoder(&buf).Encode(In{ID: 1})) {
+		assert.EqualError(t, NewDecoder(&buf).Decode(&Out{}), `encoding: required column "name" is missing`)
+	}
+	if assert.NoError(t, NewEncoder(&buf).Encode([]In{{ID: 1}})) {
+		var out []Out
+		assert.EqualError(t, NewDecoder(&buf).Decode(&out), `encoding: required column "name" is missing`)
+	}
+	var out Out
+	if roundTrip(t, Out{ID: 1, Name: "a"}, &out) {
+		assert.Equal(t, Out{ID: 1, Name: "a"}, out)
+	}
+}
+
+func Test_Default(t *testing.T) {
+	type (
+		In struct {
+			ID int64
+		}
+		Out struct {
+			ID      int64
+			Name    string        `encoder:"name,default=unknown"`
+			Count   uint16        `encoder:"count,default=10"`
+			Delta   int8          `encoder:"delta,default=-3"`
+			Ratio   float64       `encoder:"ratio,default=0.5"`
+			Enabled bool          `encoder:"enabled,default=true"`
+			Timeout time.Duration `encoder:"timeout,default=1m30s"`
+			Created time.Time     `encoder:"created,default=2024-05-01T12:00:00Z"`
+			Limit   *int          `encoder:"limit,default=100"`
+			Missing string
+		}
+	)
+	var buf bytes.Buffer
+	if assert.NoError(t, NewEncoder(&buf).Encode([]In{{ID: 1}, {ID: 2}})) {
+		var out []Out
+		if assert.NoError(t, NewDecoder(&buf).Decode(&out)) && assert.Len(t, out, 2) {
+			limit := 100
+			assert.Equal(t, Out{
+				ID:      1,
+				Name:    "unknown",
+				Count:   10,
+				Delta:   -3,
+				Ratio:   0.5,
+				Enabled: true,
+				Timeout: 90 * time.Second,
+				Created: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+				Limit:   &limit,
+			}, out[0])
+			assert.True(t, out[0].Limit != out[1].Limit)
+		}
+	}
+}
+
+func Test_DefaultInvalid(t *testing.T) {
+	type (
+		Overflow struct {
+			Count uint8 `encoder:"count,default=300"`
+		}
+		Unsupported struct {
+			Tags []string `encoder:"tags,default=a"`
+		}
+	)
+	var buf bytes.Buffer
+	assert.EqualError(t, NewEncoder(&buf).Encode(Overflow{}), `encoding: invalid default "300" for uint8: strconv.ParseUint: parsing "300": value out of range`)
+	assert.EqualError(t, NewEncoder(&buf).Encode(Unsupported{}), "encoding: default option is not supported for []string")
+}
@@ -0,0 +1,87 @@
+package encoding
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// fixedCodec returns the codecs of the fields tagged with the fixed=N option, like FixedString(N)
+// of ClickHouse their strings and byte slices are written as exactly N zero-padded bytes
+func fixedCodec(t reflect.Type, spec string) (encodeFunc, decodeFunc) {
+	size, err := strconv.Atoi(spec)
+	if err != nil || size < 1 {
+		return errorCodec(fmt.Errorf("encoding: invalid fixed size %q", spec))
+	}
+	switch {
+	case t.Kind() == reflect.Ptr:
+		encode, decode := fixedCodec(t.Elem(), spec)
+		return ptrEncodeFunc(encode), ptrDecodeFunc(decode)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		f := fixed(size)
+		return f.encodeBytes, f.decodeBytes
+	case t.Kind() == reflect.Slice:
+		encode, decode := fixedCodec(t.Elem(), spec)
+		return sliceEncodeFunc(encode), sliceDecodeFunc(decode)
+	case t.Kind() == reflect.String:
+		f := fixed(size)
+		return f.encodeString, f.decodeString
+	}
+	return errorCodec(fmt.Errorf("encoding: fixed option requires a string or []byte type, got %s", t))
+}
+
+// fixed is the size of the values written by fixedCodec
+type fixed int
+
+func (f fixed) write(enc *encode, v []byte) error {
+	if len(v) > int(f) {
+		return fmt.Errorf("encoding: value of %d bytes exceeds FixedString(%d)", len(v), f)
+	}
+	block := enc.buf.alloc(int(f))
+	clear(block[copy(block, v):])
+	return nil
+}
+
+// read returns the value without the trailing zero padding
+func (f fixed) read(d *decode) ([]byte, error) {
+	block, err := d.readFixed(int(f))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(block, "\x00"), nil
+}
+
+func (f fixed) encodeString(enc *encode, v reflect.Value) error {
+	return f.write(enc, str2bytes(v.String()))
+}
+
+func (f fixed) decodeString(d *decode, v reflect.Value) error {
+	block, err := f.read(d)
+	if err != nil {
+		return err
+	}
+	v.SetString(string(block))
+	return nil
+}
+
+func (f fixed) encodeBytes(enc *encode, v reflect.Value) error {
+	return f.write(enc, v.Bytes())
+}
+
+func (f fixed) decodeBytes(d *decode, v reflect.Value) error {
+	block, err := f.read(d)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(block) == 0:
+		v.Set(reflect.Zero(v.Type()))
+	case d.aliasBytes:
+		v.SetBytes(block)
+	default:
+		makeSlice(v, len(block))
+		copy(v.Bytes(), block)
+	}
+	return nil
+}
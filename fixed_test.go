@@ -0,0 +1,68 @@
+package encoding
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Fixed(t *testing.T) {
+	type (
+		Currency string
+		T        struct {
+			Currency Currency  `encoder:"currency,fixed=3"`
+			Country  string    `encoder:"country,fixed=2"`
+			Symbol   []byte    `encoder:"symbol,fixed=8"`
+			Empty    []byte    `encoder:"empty,fixed=4"`
+			Codes    []string  `encoder:"codes,fixed=3"`
+			Ptr      *Currency `encoder:"ptr,fixed=3"`
+		}
+	)
+	var (
+		usd = Currency("USD")
+		in  = T{
+			Currency: "EUR",
+			Country:  "D",
+			Symbol:   []byte("AAPL"),
+			Codes:    []string{"RUB", "", "GB"},
+			Ptr:      &usd,
+		}
+		out = T{Empty: []byte("stale")}
+	)
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_FixedSize(t *testing.T) {
+	type T struct {
+		Currency string `encoder:"currency,fixed=3"`
+	}
+	enc := encode{
+		buf: newBuffer(32),
+	}
+	field := fields(reflect.TypeOf(T{}))[0]
+	if assert.NoError(t, field.encode(&enc, reflect.ValueOf("EU"))) {
+		assert.Equal(t, []byte{'E', 'U', 0}, enc.buf.bytes())
+	}
+}
+
+func Test_FixedErrors(t *testing.T) {
+	type (
+		Long struct {
+			Currency string `encoder:"currency,fixed=3"`
+		}
+		Invalid struct {
+			Currency string `encoder:"currency,fixed=x"`
+		}
+		Unsupported struct {
+			Currency int `encoder:"currency,fixed=3"`
+		}
+	)
+	var buf bytes.Buffer
+	assert.EqualError(t, NewEncoder(&buf).Encode(Long{Currency: "USDT"}), "encoding: value of 4 bytes exceeds FixedString(3)")
+	assert.EqualError(t, NewEncoder(&buf).Encode(Invalid{}), `encoding: invalid fixed size "x"`)
+	assert.EqualError(t, NewEncoder(&buf).Encode(Unsupported{}), "encoding: fixed option requires a string or []byte type, got int")
+}
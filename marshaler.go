@@ -0,0 +1,213 @@
+package encoding
+
+import (
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Marshaler is implemented by types that write themselves with the primitives of Writer
+type Marshaler interface {
+	MarshalEncoding(*Writer) error
+}
+
+// Unmarshaler is implemented by types that read themselves back with the primitives of Reader,
+// UnmarshalEncoding must read exactly what MarshalEncoding has written
+type Unmarshaler interface {
+	UnmarshalEncoding(*Reader) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// Writer writes the low-level primitives of the format, it is only valid during the MarshalEncoding call
+type Writer struct {
+	enc *encode
+}
+
+func (w *Writer) WriteBool(v bool) error         { return w.enc.bool(v) }
+func (w *Writer) WriteUint8(v uint8) error       { return w.enc.uint8(v) }
+func (w *Writer) WriteUint16(v uint16) error     { return w.enc.uint16(v) }
+func (w *Writer) WriteUint32(v uint32) error     { return w.enc.uint32(v) }
+func (w *Writer) WriteUint64(v uint64) error     { return w.enc.uint64(v) }
+func (w *Writer) WriteFloat32(v float32) error   { return w.enc.float32(v) }
+func (w *Writer) WriteFloat64(v float64) error   { return w.enc.float64(v) }
+func (w *Writer) WriteUvarint(v uint64) error    { return w.enc.uvarint(v) }
+func (w *Writer) WriteVarint(v int64) error      { return w.enc.int64(v) }
+func (w *Writer) WriteString(v string) error     { return w.enc.string(v) }
+func (w *Writer) WriteBytes(v []byte) error      { return w.enc.bytes(v) }
+func (w *Writer) WriteTime(v time.Time) error    { return w.enc.time(v) }
+func (w *Writer) Write(data []byte) (int, error) { return w.enc.buf.Write(data) }
+
+// Reader reads the low-level primitives of the format, it is only valid during the UnmarshalEncoding call
+type Reader struct {
+	decode *decode
+}
+
+func (r *Reader) ReadBool() (bool, error)       { return r.decode.bool() }
+func (r *Reader) ReadUint8() (uint8, error)     { return r.decode.uint8() }
+func (r *Reader) ReadUint16() (uint16, error)   { return r.decode.uint16() }
+func (r *Reader) ReadUint32() (uint32, error)   { return r.decode.uint32() }
+func (r *Reader) ReadUint64() (uint64, error)   { return r.decode.uint64() }
+func (r *Reader) ReadFloat32() (float32, error) { return r.decode.float32() }
+func (r *Reader) ReadFloat64() (float64, error) { return r.decode.float64() }
+func (r *Reader) ReadUvarint() (uint64, error)  { return r.decode.uvarint() }
+func (r *Reader) ReadVarint() (int64, error)    { return r.decode.int64() }
+func (r *Reader) ReadString() (string, error)   { return r.decode.string() }
+func (r *Reader) ReadTime() (time.Time, error)  { return r.decode.time() }
+
+// ReadBytes returns a copy of the bytes written by Writer.WriteBytes
+func (r *Reader) ReadBytes() ([]byte, error) {
+	bytes, err := r.decode.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), bytes...), nil
+}
+
+// Read reads exactly len(p) bytes written by Writer.Write
+func (r *Reader) Read(p []byte) (int, error) {
+	bytes, err := r.decode.readFixed(len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, bytes), nil
+}
+
+// marshalerError reports a type which implements only one of Marshaler and Unmarshaler,
+// the other side would use a different codec and read the values back wrong
+func marshalerError(t reflect.Type) error {
+	var (
+		marshaler   = reflect.PointerTo(t).Implements(marshalerType)
+		unmarshaler = reflect.PointerTo(t).Implements(unmarshalerType)
+	)
+	switch {
+	case marshaler && !unmarshaler:
+		return fmt.Errorf("encoding: %s implements Marshaler but not Unmarshaler", t)
+	case unmarshaler && !marshaler:
+		return fmt.Errorf("encoding: %s implements Unmarshaler but not Marshaler", t)
+	}
+	return nil
+}
+
+func encodeMarshaler(enc *encode, v reflect.Value) error {
+	return v.Interface().(Marshaler).MarshalEncoding(&Writer{enc: enc})
+}
+
+// encodeAddrMarshaler is used for the types which implement Marshaler with a pointer receiver
+func encodeAddrMarshaler(enc *encode, v reflect.Value) error {
+	return addressable(v).Addr().Interface().(Marshaler).MarshalEncoding(&Writer{enc: enc})
+}
+
+func decodeUnmarshaler(d *decode, v reflect.Value) error {
+	return v.Addr().Interface().(Unmarshaler).UnmarshalEncoding(&Reader{decode: d})
+}
+
+// blobs are the standard marshaling interfaces, in the order of preference,
+// whose output is written as a length-prefixed blob
+var blobs = []struct {
+	marshaler   reflect.Type
+	unmarshaler reflect.Type
+	marshal     func(interface{}) ([]byte, error)
+	unmarshal   func(interface{}, []byte) error
+}{
+	{
+		marshaler:   reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem(),
+		unmarshaler: reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem(),
+		marshal:     func(v interface{}) ([]byte, error) { return v.(encoding.BinaryMarshaler).MarshalBinary() },
+		unmarshal:   func(v interface{}, data []byte) error { return v.(encoding.BinaryUnmarshaler).UnmarshalBinary(data) },
+	},
+	{
+		marshaler:   reflect.TypeOf((*gob.GobEncoder)(nil)).Elem(),
+		unmarshaler: reflect.TypeOf((*gob.GobDecoder)(nil)).Elem(),
+		marshal:     func(v interface{}) ([]byte, error) { return v.(gob.GobEncoder).GobEncode() },
+		unmarshal:   func(v interface{}, data []byte) error { return v.(gob.GobDecoder).GobDecode(data) },
+	},
+	{
+		marshaler:   textMarshalerType,
+		unmarshaler: textUnmarshalerType,
+		marshal:     func(v interface{}) ([]byte, error) { return v.(encoding.TextMarshaler).MarshalText() },
+		unmarshal:   func(v interface{}, data []byte) error { return v.(encoding.TextUnmarshaler).UnmarshalText(data) },
+	},
+}
+
+// promotedMarshaler reports whether the struct type t implements one of the marshaling interfaces
+// through an anonymous field. Its other fields would be lost by the promoted methods, so such
+// a struct is written column-wise and the anonymous field is a column of its own
+func promotedMarshaler(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+		methods := f.Type
+		if kind := methods.Kind(); kind != reflect.Ptr && kind != reflect.Interface {
+			methods = reflect.PointerTo(methods)
+		}
+		if methods.Implements(marshalerType) || methods.Implements(unmarshalerType) {
+			return true
+		}
+		for _, blob := range blobs {
+			if methods.Implements(blob.marshaler) || methods.Implements(blob.unmarshaler) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// blobEncodeFunc returns the codec of the types that implement both sides of one of the blobs interfaces, or nil
+func blobEncodeFunc(t reflect.Type) encodeFunc {
+	for _, blob := range blobs {
+		if !reflect.PointerTo(t).Implements(blob.unmarshaler) {
+			continue
+		}
+		marshal := blob.marshal
+		switch {
+		case t.Implements(blob.marshaler):
+			return func(enc *encode, v reflect.Value) error {
+				data, err := marshal(v.Interface())
+				if err != nil {
+					return err
+				}
+				return enc.bytes(data)
+			}
+		case reflect.PointerTo(t).Implements(blob.marshaler):
+			return func(enc *encode, v reflect.Value) error {
+				data, err := marshal(addressable(v).Addr().Interface())
+				if err != nil {
+					return err
+				}
+				return enc.bytes(data)
+			}
+		}
+	}
+	return nil
+}
+
+// blobDecodeFunc returns the decoder of the blobs written by the codec of blobEncodeFunc, or nil
+func blobDecodeFunc(t reflect.Type) decodeFunc {
+	for _, blob := range blobs {
+		if !reflect.PointerTo(t).Implements(blob.unmarshaler) {
+			continue
+		}
+		if t.Implements(blob.marshaler) || reflect.PointerTo(t).Implements(blob.marshaler) {
+			unmarshal := blob.unmarshal
+			return func(d *decode, v reflect.Value) error {
+				data, err := d.bytes()
+				if err != nil {
+					return err
+				}
+				return unmarshal(v.Addr().Interface(), data)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bitset writes only the words up to the last non-zero one
+type bitset struct {
+	words []uint64
+}
+
+func (b *bitset) set(i int) {
+	for len(b.words) <= i/64 {
+		b.words = append(b.words, 0)
+	}
+	b.words[i/64] |= 1 << uint(i%64)
+}
+
+func (b *bitset) MarshalEncoding(w *Writer) error {
+	ln := len(b.words)
+	for ln > 0 && b.words[ln-1] == 0 {
+		ln--
+	}
+	if err := w.WriteUvarint(uint64(ln)); err != nil {
+		return err
+	}
+	for _, word := range b.words[:ln] {
+		if err := w.WriteUint64(word); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bitset) UnmarshalEncoding(r *Reader) error {
+	ln, err := r.ReadUvarint()
+	if err != nil {
+		return err
+	}
+	b.words = make([]uint64, ln)
+	for i := range b.words {
+		if b.words[i], err = r.ReadUint64(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// customID is a value receiver Marshaler
+type customID struct {
+	prefix string
+	id     int64
+}
+
+func (c customID) MarshalEncoding(w *Writer) error {
+	if err := w.WriteString(c.prefix); err != nil {
+		return err
+	}
+	return w.WriteVarint(c.id)
+}
+
+func (c *customID) UnmarshalEncoding(r *Reader) (err error) {
+	if c.prefix, err = r.ReadString(); err != nil {
+		return err
+	}
+	c.id, err = r.ReadVarint()
+	return err
+}
+
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalEncoding(*Writer) error    { return errors.New("failed") }
+func (*failingMarshaler) UnmarshalEncoding(*Reader) error { return errors.New("failed") }
+
+// marshalOnly implements Marshaler without Unmarshaler
+type marshalOnly uint32
+
+func (marshalOnly) MarshalEncoding(w *Writer) error { return w.WriteString("text") }
+
+// unmarshalOnly implements Unmarshaler without Marshaler
+type unmarshalOnly uint32
+
+func (*unmarshalOnly) UnmarshalEncoding(r *Reader) error { return nil }
+
+func Test_Marshaler(t *testing.T) {
+	type T struct {
+		Bits   bitset
+		Ptr    *bitset
+		ID     customID
+		IDs    []customID
+		ByName map[string]customID
+	}
+	var bits bitset
+	bits.set(1)
+	bits.set(130)
+	in := T{
+		Bits:   bits,
+		Ptr:    &bitset{words: []uint64{1, 2}},
+		ID:     customID{"user", -1},
+		IDs:    []customID{{"a", 1}, {"b", 2}},
+		ByName: map[string]customID{"c": {"c", 3}},
+	}
+	for _, v := range []interface{}{in, &in} {
+		var out T
+		if roundTrip(t, v, &out) {
+			assert.Equal(t, in, out)
+		}
+	}
+}
+
+func Test_MarshalerCompact(t *testing.T) {
+	enc := encode{
+		buf: newBuffer(16),
+	}
+	if assert.NoError(t, getEncodeFunc(reflect.TypeOf(bitset{}))(&enc, reflect.ValueOf(&bitset{words: []uint64{0, 0}}).Elem())) {
+		assert.Equal(t, []byte{0}, enc.buf.bytes())
+	}
+}
+
+func Test_MarshalerError(t *testing.T) {
+	var buf bytes.Buffer
+	assert.EqualError(t, NewEncoder(&buf).Encode(struct{ V failingMarshaler }{}), "failed")
+}
+
+func Test_MarshalerOneSided(t *testing.T) {
+	var buf bytes.Buffer
+	assert.EqualError(t, NewEncoder(&buf).Encode(struct{ V marshalOnly }{}), "encoding: encoding.marshalOnly implements Marshaler but not Unmarshaler")
+	assert.EqualError(t, NewEncoder(&buf).Encode(struct{ V unmarshalOnly }{}), "encoding: encoding.unmarshalOnly implements Unmarshaler but not Marshaler")
+	if assert.NoError(t, NewEncoder(&buf).Encode(struct{ V uint32 }{})) {
+		var out struct{ V marshalOnly }
+		assert.EqualError(t, NewDecoder(&buf).Decode(&out), "encoding: encoding.marshalOnly implements Marshaler but not Unmarshaler")
+	}
+}
+
+// tagID is a fixed-width type with a Marshaler, its slices and arrays are not packed
+type tagID uint32
+
+func (id tagID) MarshalEncoding(w *Writer) error { return w.WriteString(strconv.Itoa(int(id))) }
+
+func (id *tagID) UnmarshalEncoding(r *Reader) error {
+	s, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	v, err := strconv.Atoi(s)
+	*id = tagID(v)
+	return err
+}
+
+// binaryByte is a byte with a BinaryMarshaler, its slices are not written as []byte
+type binaryByte uint8
+
+func (b binaryByte) MarshalBinary() ([]byte, error) { return []byte{'b', byte(b)}, nil }
+
+func (b *binaryByte) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 || data[0] != 'b' {
+		return errors.New("invalid binaryByte")
+	}
+	*b = binaryByte(data[1])
+	return nil
+}
+
+func Test_MarshalerElements(t *testing.T) {
+	type T struct {
+		IDs   []tagID
+		Array [2]tagID
+		Bytes []binaryByte
+		Fixed [2]binaryByte
+	}
+	in := T{
+		IDs:   []tagID{1, 22},
+		Array: [2]tagID{333, 4444},
+		Bytes: []binaryByte{5, 6},
+		Fixed: [2]binaryByte{7, 8},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	enc := encode{
+		buf: newBuffer(16),
+	}
+	if assert.NoError(t, getEncodeFunc(reflect.TypeOf(in.IDs))(&enc, reflect.ValueOf(in.IDs))) {
+		assert.Equal(t, []byte{2, 1, '1', 2, '2', '2'}, enc.buf.bytes())
+	}
+}
+
+// textOnly implements encoding.TextMarshaler only
+type textOnly struct {
+	v string
+}
+
+func (t textOnly) MarshalText() ([]byte, error) { return []byte("text:" + t.v), nil }
+
+func (t *textOnly) UnmarshalText(data []byte) error {
+	t.v = strings.TrimPrefix(string(data), "text:")
+	return nil
+}
+
+// gobOnly implements gob.GobEncoder and both text interfaces, GobEncode is preferred
+type gobOnly struct {
+	v uint16
+}
+
+func (g gobOnly) GobEncode() ([]byte, error) { return []byte{byte(g.v), byte(g.v >> 8)}, nil }
+
+func (g *gobOnly) GobDecode(data []byte) error {
+	if len(data) != 2 {
+		return errors.New("invalid length")
+	}
+	g.v = uint16(data[0]) | uint16(data[1])<<8
+	return nil
+}
+
+func (g gobOnly) MarshalText() ([]byte, error) { return nil, errors.New("unexpected MarshalText") }
+func (g *gobOnly) UnmarshalText([]byte) error  { return errors.New("unexpected UnmarshalText") }
+
+func Test_StandardMarshalers(t *testing.T) {
+	type T struct {
+		URL   url.URL
+		URLs  []*url.URL
+		Int   *big.Int
+		Text  textOnly
+		Texts []textOnly
+		Gob   gobOnly
+	}
+	u, err := url.Parse("https://user@example.com:8080/path?q=1#frag")
+	if !assert.NoError(t, err) {
+		return
+	}
+	in := T{
+		URL:   *u,
+		URLs:  []*url.URL{u, nil},
+		Int:   new(big.Int).Lsh(big.NewInt(-3), 100),
+		Text:  textOnly{"a"},
+		Texts: []textOnly{{"b"}, {"c"}},
+		Gob:   gobOnly{0xbeef},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in.URL.String(), out.URL.String())
+		if assert.Len(t, out.URLs, 2) {
+			assert.Equal(t, u.String(), out.URLs[0].String())
+			assert.Nil(t, out.URLs[1])
+		}
+		assert.Equal(t, 0, in.Int.Cmp(out.Int))
+		assert.Equal(t, in.Text, out.Text)
+		assert.Equal(t, in.Texts, out.Texts)
+		assert.Equal(t, in.Gob, out.Gob)
+	}
+}
+
+func Test_TextMarshalerBlob(t *testing.T) {
+	enc := encode{
+		buf: newBuffer(16),
+	}
+	if assert.NoError(t, getEncodeFunc(reflect.TypeOf(textOnly{}))(&enc, reflect.ValueOf(textOnly{"a"}))) {
+		assert.Equal(t, []byte{6, 't', 'e', 'x', 't', ':', 'a'}, enc.buf.bytes())
+	}
+}
+
+func Test_PromotedMarshalers(t *testing.T) {
+	type (
+		Event struct {
+			time.Time
+			Name string
+		}
+		Endpoint struct {
+			netip.Addr
+			Port uint16
+		}
+		// Exported names of the fixtures, unexported embedded fields are not written
+		ID     = customID
+		Bits   = bitset
+		Tagged struct {
+			ID
+			*Bits
+			Value string
+		}
+		T struct {
+			Event     Event
+			Events    []Event
+			Endpoints map[Endpoint]string
+			Tagged    Tagged
+		}
+	)
+	var bits bitset
+	bits.set(3)
+	in := T{
+		Event:  Event{Time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), Name: "start"},
+		Events: []Event{{Name: "a"}, {Time: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), Name: "b"}},
+		Endpoints: map[Endpoint]string{
+			{Addr: netip.MustParseAddr("10.0.0.1"), Port: 80}:  "http",
+			{Addr: netip.MustParseAddr("10.0.0.1"), Port: 443}: "https",
+		},
+		Tagged: Tagged{ID: ID{"x", 1}, Bits: &bits, Value: "v"},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	var endpoint Endpoint
+	if roundTrip(t, Endpoint{Addr: netip.MustParseAddr("::1"), Port: 8080}, &endpoint) {
+		assert.Equal(t, Endpoint{Addr: netip.MustParseAddr("::1"), Port: 8080}, endpoint)
+	}
+}
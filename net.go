@@ -0,0 +1,222 @@
+package encoding
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+)
+
+// Address families written before the address bytes
+const (
+	familyNone = 0
+	familyV4   = 4
+	familyV6   = 6
+	// familyV6Zone is an IPv6 address followed by its zone
+	familyV6Zone = 7
+)
+
+var (
+	netipAddrType   = reflect.TypeOf(netip.Addr{})
+	netipPrefixType = reflect.TypeOf(netip.Prefix{})
+	netIPType       = reflect.TypeOf(net.IP{})
+)
+
+func (enc *encode) addr(v netip.Addr) error {
+	switch {
+	case !v.IsValid():
+		return enc.uint8(familyNone)
+	case v.Is4():
+		ip := v.As4()
+		if err := enc.uint8(familyV4); err != nil {
+			return err
+		}
+		_, err := enc.buf.Write(ip[:])
+		return err
+	}
+	family := uint8(familyV6)
+	if v.Zone() != "" {
+		family = familyV6Zone
+	}
+	if err := enc.uint8(family); err != nil {
+		return err
+	}
+	ip := v.As16()
+	if _, err := enc.buf.Write(ip[:]); err != nil {
+		return err
+	}
+	if family == familyV6Zone {
+		return enc.string(v.Zone())
+	}
+	return nil
+}
+
+func (decode *decode) addr() (netip.Addr, error) {
+	family, err := decode.uint8()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	switch family {
+	case familyNone:
+		return netip.Addr{}, nil
+	case familyV4:
+		b, err := decode.readFixed(4)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return netip.AddrFrom4([4]byte(b)), nil
+	case familyV6, familyV6Zone:
+		b, err := decode.readFixed(16)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		addr := netip.AddrFrom16([16]byte(b))
+		if family == familyV6Zone {
+			zone, err := decode.string()
+			if err != nil {
+				return netip.Addr{}, err
+			}
+			addr = addr.WithZone(zone)
+		}
+		return addr, nil
+	}
+	return netip.Addr{}, fmt.Errorf("encoding: invalid address family %d", family)
+}
+
+func encodeNetipAddr(enc *encode, v reflect.Value) error {
+	return enc.addr(v.Interface().(netip.Addr))
+}
+
+func decodeNetipAddr(d *decode, v reflect.Value) error {
+	addr, err := d.addr()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(addr))
+	return nil
+}
+
+// encodeNetipPrefix writes the address followed by the number of bits, 0xff for an invalid prefix length
+func encodeNetipPrefix(enc *encode, v reflect.Value) error {
+	prefix := v.Interface().(netip.Prefix)
+	if err := enc.addr(prefix.Addr()); err != nil {
+		return err
+	}
+	return enc.uint8(uint8(prefix.Bits()))
+}
+
+func decodeNetipPrefix(d *decode, v reflect.Value) error {
+	addr, err := d.addr()
+	if err != nil {
+		return err
+	}
+	bits, err := d.uint8()
+	if err != nil {
+		return err
+	}
+	prefix := netip.Prefix{}
+	if addr.IsValid() {
+		prefix = netip.PrefixFrom(addr, int(int8(bits)))
+	}
+	v.Set(reflect.ValueOf(prefix))
+	return nil
+}
+
+// encodeNetIP keeps the length of the address, a 4-byte IPv4 address is not turned into a 16-byte one
+func encodeNetIP(enc *encode, v reflect.Value) error {
+	ip := v.Bytes()
+	switch len(ip) {
+	case 0:
+		return enc.uint8(familyNone)
+	case net.IPv4len:
+		if err := enc.uint8(familyV4); err != nil {
+			return err
+		}
+	case net.IPv6len:
+		if err := enc.uint8(familyV6); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("encoding: invalid net.IP length %d", len(ip))
+	}
+	_, err := enc.buf.Write(ip)
+	return err
+}
+
+func decodeNetIP(d *decode, v reflect.Value) error {
+	family, err := d.uint8()
+	if err != nil {
+		return err
+	}
+	var ln int
+	switch family {
+	case familyNone:
+		v.SetBytes(nil)
+		return nil
+	case familyV4:
+		ln = net.IPv4len
+	case familyV6:
+		ln = net.IPv6len
+	default:
+		return fmt.Errorf("encoding: invalid address family %d", family)
+	}
+	b, err := d.readFixed(ln)
+	if err != nil {
+		return err
+	}
+	v.SetBytes(append(make([]byte, 0, ln), b...))
+	return nil
+}
+
+// uuidCodec returns the codecs of the fields tagged with the uuid option, which are
+// written as raw 16 bytes even if their type implements one of the marshaling interfaces
+func uuidCodec(t reflect.Type) (encodeFunc, decodeFunc) {
+	switch {
+	case t.Kind() == reflect.Ptr:
+		encode, decode := uuidCodec(t.Elem())
+		return ptrEncodeFunc(encode), ptrDecodeFunc(decode)
+	case t.Kind() == reflect.Slice:
+		encode, decode := uuidCodec(t.Elem())
+		return sliceEncodeFunc(encode), sliceDecodeFunc(decode)
+	case t.Kind() == reflect.Array && t.Len() == 16 && t.Elem().Kind() == reflect.Uint8:
+		return newArrayEncodeFunc(t), newArrayDecodeFunc(t)
+	}
+	return errorCodec(fmt.Errorf("encoding: uuid option requires a [16]byte type, got %s", t))
+}
+
+// sliceEncodeFunc returns the codec of slices whose elements are written by fn
+func sliceEncodeFunc(fn encodeFunc) encodeFunc {
+	return func(enc *encode, v reflect.Value) error {
+		ln := v.Len()
+		if err := enc.uvarint(uint64(ln)); err != nil {
+			return err
+		}
+		for i := 0; i < ln; i++ {
+			if err := fn(enc, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// sliceDecodeFunc returns the decoder of slices whose elements are read by fn
+func sliceDecodeFunc(fn decodeFunc) decodeFunc {
+	return func(d *decode, v reflect.Value) error {
+		ln, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		if ln > uint64(d.remaining()) {
+			return io.ErrUnexpectedEOF
+		}
+		makeSlice(v, int(ln))
+		for i := 0; i < int(ln); i++ {
+			if err := fn(d, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// netUUID mimics the UUID types which marshal themselves as text
+type netUUID [16]byte
+
+func (u netUUID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(u[:])), nil
+}
+
+func (u *netUUID) UnmarshalText(text []byte) error {
+	_, err := hex.Decode(u[:], text)
+	return err
+}
+
+func Test_Netip(t *testing.T) {
+	type T struct {
+		V4       netip.Addr
+		V6       netip.Addr
+		V4In6    netip.Addr
+		Zoned    netip.Addr
+		Invalid  netip.Addr
+		Prefix   netip.Prefix
+		Prefix6  netip.Prefix
+		NoPrefix netip.Prefix
+		Addrs    []netip.Addr
+		Ptr      *netip.Addr
+	}
+	addr := netip.MustParseAddr("10.0.0.1")
+	in := T{
+		V4:      addr,
+		V6:      netip.MustParseAddr("2001:db8::1"),
+		V4In6:   netip.MustParseAddr("::ffff:10.0.0.1"),
+		Zoned:   netip.MustParseAddr("fe80::1%eth0"),
+		Prefix:  netip.MustParsePrefix("10.0.0.0/8"),
+		Prefix6: netip.MustParsePrefix("2001:db8::/32"),
+		Addrs:   []netip.Addr{addr, {}},
+		Ptr:     &addr,
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_NetipSize(t *testing.T) {
+	enc := encode{
+		buf: newBuffer(32),
+	}
+	if assert.NoError(t, enc.addr(netip.MustParseAddr("10.0.0.1"))) {
+		assert.Equal(t, []byte{familyV4, 10, 0, 0, 1}, enc.buf.bytes())
+	}
+}
+
+func Test_NetIP(t *testing.T) {
+	type T struct {
+		V4  net.IP
+		V6  net.IP
+		Nil net.IP
+		IPs []net.IP
+	}
+	in := T{
+		V4:  net.IPv4(10, 0, 0, 1).To4(),
+		V6:  net.ParseIP("2001:db8::1"),
+		IPs: []net.IP{net.ParseIP("10.0.0.2"), net.IPv4(10, 0, 0, 3).To4()},
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	var buf bytes.Buffer
+	assert.Error(t, NewEncoder(&buf).Encode(T{V4: net.IP{1, 2, 3}}))
+}
+
+func Test_UUID(t *testing.T) {
+	type T struct {
+		ID   netUUID   `encoder:"id,uuid"`
+		Ptr  *netUUID  `encoder:"ptr,uuid"`
+		IDs  []netUUID `encoder:"ids,uuid"`
+		Text netUUID
+	}
+	id := netUUID{0: 0xde, 1: 0xad, 15: 0xff}
+	in := T{
+		ID:   id,
+		Ptr:  &id,
+		IDs:  []netUUID{id, {}},
+		Text: id,
+	}
+	var out T
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	enc := encode{
+		buf: newBuffer(32),
+	}
+	if field := fields(reflect.TypeOf(T{}))[0]; assert.NoError(t, field.encode(&enc, reflect.ValueOf(id))) {
+		assert.Equal(t, id[:], enc.buf.bytes())
+	}
+}
+
+func Test_UUIDInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	assert.EqualError(t, NewEncoder(&buf).Encode(struct {
+		ID string `encoder:"id,uuid"`
+	}{}), "encoding: uuid option requires a [16]byte type, got string")
+}
@@ -0,0 +1,64 @@
+package encoding
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Optional is a value that may be absent, like the sql.Null types
+// it is written as a presence byte followed by Value when Valid is set
+type Optional[T any] struct {
+	Value T
+	Valid bool
+}
+
+var optionalPkgPath = reflect.TypeOf(Optional[struct{}]{}).PkgPath()
+
+// nullable reports whether t is an Optional or one of the sql.Null types,
+// they hold the value in the first field and its presence in Valid.
+// The types are matched by name, the structs embedding them are encoded as usual
+func nullable(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() != 2 {
+		return false
+	}
+	switch {
+	case t.PkgPath() == optionalPkgPath && strings.HasPrefix(t.Name(), "Optional["):
+		return true
+	case t.PkgPath() == "database/sql" && strings.HasPrefix(t.Name(), "Null"):
+		valid := t.Field(1)
+		return valid.Name == "Valid" && valid.Type.Kind() == reflect.Bool
+	}
+	return false
+}
+
+func nullableEncodeFunc(t reflect.Type) encodeFunc {
+	fn := lazyEncodeFunc(t.Field(0).Type)
+	return func(enc *encode, v reflect.Value) error {
+		if !v.Field(1).Bool() {
+			return enc.bool(false)
+		}
+		if err := enc.bool(true); err != nil {
+			return err
+		}
+		return fn(enc, v.Field(0))
+	}
+}
+
+func nullableDecodeFunc(t reflect.Type) decodeFunc {
+	fn := lazyDecodeFunc(t.Field(0).Type)
+	return func(d *decode, v reflect.Value) error {
+		valid, err := d.bool()
+		if err != nil {
+			return err
+		}
+		if !valid {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if err := fn(d, v.Field(0)); err != nil {
+			return err
+		}
+		v.Field(1).SetBool(true)
+		return nil
+	}
+}
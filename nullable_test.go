@@ -0,0 +1,96 @@
+package encoding
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Nullable(t *testing.T) {
+	type T struct {
+		String   sql.NullString
+		Int64    sql.NullInt64
+		Int32    sql.NullInt32
+		Float64  sql.NullFloat64
+		Bool     sql.NullBool
+		Byte     sql.NullByte
+		Time     sql.NullTime
+		Generic  sql.Null[uint16]
+		Null     sql.NullString
+		Optional Optional[string]
+		Slice    Optional[[]int]
+		Empty    Optional[int]
+	}
+	in := T{
+		String:   sql.NullString{String: "abc", Valid: true},
+		Int64:    sql.NullInt64{Int64: -42, Valid: true},
+		Int32:    sql.NullInt32{Int32: 42, Valid: true},
+		Float64:  sql.NullFloat64{Float64: 4.2, Valid: true},
+		Bool:     sql.NullBool{Valid: true},
+		Byte:     sql.NullByte{Byte: 7, Valid: true},
+		Time:     sql.NullTime{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Valid: true},
+		Generic:  sql.Null[uint16]{V: 65535, Valid: true},
+		Optional: Optional[string]{Value: "def", Valid: true},
+		Slice:    Optional[[]int]{Value: []int{1, 2, 3}, Valid: true},
+	}
+	out := T{
+		Null:  sql.NullString{String: "stale", Valid: true},
+		Empty: Optional[int]{Value: 1},
+	}
+	if roundTrip(t, in, &out) {
+		assert.True(t, in.Time.Time.Equal(out.Time.Time))
+		in.Time, out.Time = sql.NullTime{}, sql.NullTime{}
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_NullableEmbedded(t *testing.T) {
+	type (
+		Named    Optional[int]
+		Embedded struct {
+			Optional[int]
+			Extra string
+		}
+	)
+	in := Embedded{Optional: Optional[int]{Value: 1, Valid: true}, Extra: "a"}
+	var out Embedded
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+	assert.False(t, nullable(reflect.TypeOf(Embedded{})))
+	assert.False(t, nullable(reflect.TypeOf(Named{})))
+}
+
+func Test_NullableColumns(t *testing.T) {
+	type Row struct {
+		ID   int64
+		Name sql.NullString
+		Age  Optional[uint8]
+	}
+	in := []Row{
+		{ID: 1, Name: sql.NullString{String: "a", Valid: true}},
+		{ID: 2, Age: Optional[uint8]{Value: 30, Valid: true}},
+	}
+	var out []Row
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_NullableSize(t *testing.T) {
+	enc := encode{
+		buf: newBuffer(32),
+	}
+	for _, v := range []interface{}{
+		sql.NullInt64{},
+		sql.NullInt64{Int64: 300, Valid: true},
+		Optional[string]{Value: "a", Valid: true},
+	} {
+		value := reflect.ValueOf(v)
+		assert.NoError(t, getEncodeFunc(value.Type())(&enc, value))
+	}
+	assert.Equal(t, []byte{0, 1, 0xd8, 0x04, 1, 1, 'a'}, enc.buf.bytes())
+}
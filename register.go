@@ -0,0 +1,110 @@
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var registry struct {
+	mutex sync.RWMutex
+	names map[reflect.Type]string
+	types map[string]reflect.Type
+	// unions are the variants of the interface types registered by RegisterUnion
+	unions map[reflect.Type][]reflect.Type
+}
+
+func init() {
+	registry.names = make(map[reflect.Type]string)
+	registry.types = make(map[string]reflect.Type)
+	registry.unions = make(map[reflect.Type][]reflect.Type)
+}
+
+// Register records the concrete type of value under name, so that it can be
+// stored in interface fields. The name is written before the value and is used
+// by Decoder to allocate the same type. Like gob.Register, it panics if the
+// type or the name is already registered for a different name or type
+func Register(name string, value interface{}) {
+	if name == "" {
+		panic("encoding: empty name in Register")
+	}
+	if value == nil {
+		panic("encoding: nil value in Register")
+	}
+	t := reflect.TypeOf(value)
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if n, ok := registry.names[t]; ok && n != name {
+		panic(fmt.Sprintf("encoding: registering duplicate types for %q: %s", name, t))
+	}
+	if r, ok := registry.types[name]; ok && r != t {
+		panic(fmt.Sprintf("encoding: registering duplicate names for %s: %q", t, name))
+	}
+	registry.names[t] = name
+	registry.types[name] = t
+}
+
+// RegisterUnion declares the interface type iface points to as a union of the types of variants.
+// Values of the interface are written as the position of their type in variants, starting from 1,
+// followed by the value, so new variants must be appended at the end. It panics if a variant
+// does not implement the interface or the union is already registered with other variants
+func RegisterUnion(iface interface{}, variants ...interface{}) {
+	t := reflect.TypeOf(iface)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		panic(fmt.Sprintf("encoding: RegisterUnion requires a pointer to an interface, got %T", iface))
+	}
+	t = t.Elem()
+	if len(variants) == 0 {
+		panic(fmt.Sprintf("encoding: no variants in RegisterUnion for %s", t))
+	}
+	types := make([]reflect.Type, 0, len(variants))
+	for _, variant := range variants {
+		v := reflect.TypeOf(variant)
+		switch {
+		case v == nil:
+			panic(fmt.Sprintf("encoding: nil variant in RegisterUnion for %s", t))
+		case !v.Implements(t):
+			panic(fmt.Sprintf("encoding: variant %s does not implement %s", v, t))
+		}
+		for _, r := range types {
+			if r == v {
+				panic(fmt.Sprintf("encoding: duplicate variant %s in RegisterUnion for %s", v, t))
+			}
+		}
+		types = append(types, v)
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if r, ok := registry.unions[t]; ok && !reflect.DeepEqual(r, types) {
+		panic(fmt.Sprintf("encoding: registering different variants for %s", t))
+	}
+	registry.unions[t] = types
+}
+
+// unionVariants returns the variants of the interface type t, if it is a registered union
+func unionVariants(t reflect.Type) ([]reflect.Type, bool) {
+	registry.mutex.RLock()
+	variants, ok := registry.unions[t]
+	registry.mutex.RUnlock()
+	return variants, ok
+}
+
+func registeredName(t reflect.Type) (string, error) {
+	registry.mutex.RLock()
+	name, ok := registry.names[t]
+	registry.mutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("encoding: type %s is not registered", t)
+	}
+	return name, nil
+}
+
+func registeredType(name string) (reflect.Type, error) {
+	registry.mutex.RLock()
+	t, ok := registry.types[name]
+	registry.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("encoding: name %q is not registered", name)
+	}
+	return t, nil
+}
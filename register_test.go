@@ -0,0 +1,152 @@
+package encoding
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	registerCreated struct {
+		ID   uint64
+		Name string
+	}
+	registerDeleted struct {
+		ID uint64
+	}
+	registerCode string
+)
+
+func (c registerCode) String() string { return string(c) }
+
+func init() {
+	Register("created", registerCreated{})
+	Register("deleted", &registerDeleted{})
+	Register("code", registerCode(""))
+	Register("uint32", uint32(0))
+}
+
+func Test_Interface(t *testing.T) {
+	type T struct {
+		Payload  interface{}
+		Payloads []interface{}
+		Map      map[string]interface{}
+		Stringer fmt.Stringer
+		Nil      interface{}
+	}
+	in := T{
+		Payload: registerCreated{ID: 1, Name: "a"},
+		Payloads: []interface{}{
+			&registerDeleted{ID: 2},
+			registerCode("abc"),
+			uint32(42),
+			nil,
+		},
+		Map: map[string]interface{}{
+			"a": registerCreated{ID: 3},
+		},
+		Stringer: registerCode("xyz"),
+	}
+	out := T{Nil: "stale"}
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_InterfaceUnregistered(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(struct{ V interface{} }{V: 1.5})
+	assert.EqualError(t, err, "encoding: type float64 is not registered")
+}
+
+func Test_InterfaceNotImplemented(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		out struct{ V fmt.Stringer }
+	)
+	if assert.NoError(t, NewEncoder(&buf).Encode(struct{ V interface{} }{V: uint32(1)})) {
+		assert.Error(t, NewDecoder(&buf).Decode(&out))
+	}
+}
+
+func Test_RegisterDuplicate(t *testing.T) {
+	assert.NotPanics(t, func() { Register("created", registerCreated{}) })
+	assert.Panics(t, func() { Register("created", registerDeleted{}) })
+	assert.Panics(t, func() { Register("other", registerCreated{}) })
+}
+
+type (
+	unionEvent interface {
+		event()
+	}
+	unionCreated struct {
+		ID   uint64
+		Name string
+	}
+	unionDeleted struct {
+		ID uint64
+	}
+	unionRenamed struct {
+		Name string
+	}
+)
+
+func (unionCreated) event()  {}
+func (*unionDeleted) event() {}
+func (unionRenamed) event()  {}
+
+func init() {
+	RegisterUnion((*unionEvent)(nil), unionCreated{}, &unionDeleted{})
+}
+
+func Test_Union(t *testing.T) {
+	type T struct {
+		Event  unionEvent
+		Events []unionEvent
+		Nil    unionEvent
+	}
+	in := T{
+		Event: unionCreated{ID: 1, Name: "a"},
+		Events: []unionEvent{
+			&unionDeleted{ID: 2},
+			nil,
+			unionCreated{ID: 3},
+		},
+	}
+	out := T{Nil: unionCreated{}}
+	if roundTrip(t, in, &out) {
+		assert.Equal(t, in, out)
+	}
+}
+
+func Test_UnionSize(t *testing.T) {
+	enc := encode{
+		buf: newBuffer(32),
+	}
+	var v unionEvent = &unionDeleted{ID: 2}
+	if assert.NoError(t, encodeInterface(&enc, reflect.ValueOf(&v).Elem())) {
+		assert.Equal(t, []byte{2, ptrValue}, enc.buf.bytes()[:2])
+	}
+}
+
+func Test_UnionErrors(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(struct{ V unionEvent }{V: unionRenamed{}})
+	assert.EqualError(t, err, "encoding: type encoding.unionRenamed is not a variant of encoding.unionEvent")
+	if assert.NoError(t, NewEncoder(&buf).Encode([]uint8{3})) {
+		var out []unionEvent
+		buf.Bytes()[5] = 1
+		assert.EqualError(t, NewDecoder(&buf).Decode(&out), "encoding: invalid variant 3 of encoding.unionEvent")
+	}
+}
+
+func Test_RegisterUnion(t *testing.T) {
+	assert.NotPanics(t, func() { RegisterUnion((*unionEvent)(nil), unionCreated{}, &unionDeleted{}) })
+	assert.Panics(t, func() { RegisterUnion((*unionEvent)(nil), unionCreated{}) })
+	assert.Panics(t, func() { RegisterUnion(unionCreated{}, unionCreated{}) })
+	assert.Panics(t, func() { RegisterUnion((*fmt.Stringer)(nil), unionCreated{}) })
+	assert.Panics(t, func() { RegisterUnion((*unionEvent)(nil), unionDeleted{}) })
+}
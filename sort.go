@@ -0,0 +1,104 @@
+package encoding
+
+import (
+	"math"
+	"reflect"
+)
+
+// compare orders two values of the same type to sort map keys, NaN is less than any other float
+func compare(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareInt(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return compareUint(a.Uint(), b.Uint())
+	case reflect.String:
+		return compareString(a.String(), b.String())
+	case reflect.Float32, reflect.Float64:
+		return compareFloat(a.Float(), b.Float())
+	case reflect.Complex64, reflect.Complex128:
+		if c := compareFloat(real(a.Complex()), real(b.Complex())); c != 0 {
+			return c
+		}
+		return compareFloat(imag(a.Complex()), imag(b.Complex()))
+	case reflect.Bool:
+		return compareBool(a.Bool(), b.Bool())
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Chan:
+		return compareUint(uint64(a.Pointer()), uint64(b.Pointer()))
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compare(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compare(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Interface:
+		switch {
+		case a.IsNil() || b.IsNil():
+			return compareBool(!a.IsNil(), !b.IsNil())
+		case a.Elem().Type() != b.Elem().Type():
+			return compareString(a.Elem().Type().String(), b.Elem().Type().String())
+		}
+		return compare(a.Elem(), b.Elem())
+	}
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareString(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return compareBool(!math.IsNaN(a), !math.IsNaN(b))
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}